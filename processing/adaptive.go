@@ -0,0 +1,114 @@
+package processing
+
+import "time"
+
+// DefaultAdaptInterval is used when AdaptiveConfig.Interval is zero.
+const DefaultAdaptInterval = time.Second
+
+// AdaptiveConfig lets a run vary its number of workers between MinWorkers
+// and MaxWorkers. Every Interval the run compares how full the input and
+// output queues are and how long handler calls took:
+//
+//   - with input waiting and room in the output queue, it adds up to a
+//     quarter more workers, unless handler latency rose by half or more
+//     since the last time workers were added;
+//   - with no input waiting, or a nearly full output queue, it stops one
+//     worker.
+//
+// Every worker goroutine gets a fresh handler from MakeHandler. The ids of
+// stopped workers are reused, but never while another goroutine is using
+// them. The worker count passed to the run is the initial count.
+type AdaptiveConfig struct {
+	MinWorkers uint
+	MaxWorkers uint
+	Interval   time.Duration
+}
+
+func (ac *AdaptiveConfig) minWorkers() uint {
+	if ac.MinWorkers == 0 {
+		return 1
+	}
+	return ac.MinWorkers
+}
+
+func (ac *AdaptiveConfig) maxWorkers() uint {
+	if ac.MaxWorkers < ac.minWorkers() {
+		return ac.minWorkers()
+	}
+	return ac.MaxWorkers
+}
+
+func (ac *AdaptiveConfig) clamp(workers uint) uint {
+	if workers < ac.minWorkers() {
+		return ac.minWorkers()
+	}
+	if workers > ac.maxWorkers() {
+		return ac.maxWorkers()
+	}
+	return workers
+}
+
+func (ac *AdaptiveConfig) interval() time.Duration {
+	if ac.Interval <= 0 {
+		return DefaultAdaptInterval
+	}
+	return ac.Interval
+}
+
+// adapt resizes the worker pool every interval until stop is closed.
+func (p *process[In, Out]) adapt(stop <-chan struct{}) {
+	ac := p.config.Adaptive
+	ticker := time.NewTicker(ac.interval())
+	defer ticker.Stop()
+	var (
+		lastBusy    int64
+		lastHandled uint64
+		// grownLatency is the mean handler latency measured just before
+		// the pool last grew.
+		grownLatency float64
+	)
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		busy, handled := p.busy.Load(), p.handled.Load()
+		var latency float64
+		if handled > lastHandled {
+			latency = float64(busy-lastBusy) / float64(handled-lastHandled)
+		}
+		lastBusy, lastHandled = busy, handled
+
+		running := p.active.Load() - int64(len(p.shrink))
+		if running < 0 {
+			running = 0
+		}
+		workers := uint(running)
+		queued := len(p.processQueue)
+		output := len(p.outputQueue)
+		switch {
+		case queued >= cap(p.processQueue)/2 && output < cap(p.outputQueue)/2 && workers < ac.maxWorkers():
+			if grownLatency > 0 && latency > 1.5*grownLatency {
+				continue
+			}
+			grow := workers / 4
+			if grow == 0 {
+				grow = 1
+			}
+			if workers+grow > ac.maxWorkers() {
+				grow = ac.maxWorkers() - workers
+			}
+			grownLatency = latency
+			for i := uint(0); i < grow; i++ {
+				p.startWorker()
+			}
+		case (queued == 0 || output >= cap(p.outputQueue)*3/4) && workers > ac.minWorkers():
+			select {
+			case p.shrink <- struct{}{}:
+				grownLatency = 0
+			default:
+			}
+		}
+	}
+}
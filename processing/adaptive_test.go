@@ -0,0 +1,89 @@
+package processing
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type AdaptiveSuite struct{}
+
+var _ = Suite(&AdaptiveSuite{})
+
+// idWorker checks that no handler id is in use by two goroutines at once,
+// and that MakeHandler is not called concurrently.
+type idWorker struct {
+	mu      sync.Mutex
+	inUse   map[uint]bool
+	maxBusy int
+	reused  bool
+
+	making     atomic.Int32
+	concurrent atomic.Bool
+}
+
+func (w *idWorker) MakeHandler(id uint) Handler {
+	if w.making.Add(1) > 1 {
+		w.concurrent.Store(true)
+	}
+	time.Sleep(100 * time.Microsecond)
+	w.making.Add(-1)
+	return func(v interface{}) interface{} {
+		w.mu.Lock()
+		if w.inUse[id] {
+			w.reused = true
+		}
+		w.inUse[id] = true
+		if len(w.inUse) > w.maxBusy {
+			w.maxBusy = len(w.inUse)
+		}
+		w.mu.Unlock()
+		time.Sleep(time.Millisecond)
+		w.mu.Lock()
+		delete(w.inUse, id)
+		w.mu.Unlock()
+		return v
+	}
+}
+
+func (w *idWorker) Success() uint { return 0 }
+func (w *idWorker) Failure() uint { return 0 }
+func (w *idWorker) Total() uint   { return 0 }
+func (w *idWorker) Done()         {}
+
+func (s *AdaptiveSuite) TestGrows(c *C) {
+	w := &idWorker{inUse: make(map[uint]bool)}
+	out := new(sliceEncoder)
+	config := &Config{
+		Adaptive: &AdaptiveConfig{MinWorkers: 1, MaxWorkers: 8, Interval: 2 * time.Millisecond},
+	}
+	err := ProcessConfig(context.Background(), &sliceDecoder{values: intRange(1000)}, out, w, 1, config)
+	c.Assert(err, IsNil)
+	c.Check(out.values, HasLen, 1000)
+	c.Check(w.maxBusy > 1, Equals, true)
+	c.Check(w.maxBusy <= 8, Equals, true)
+	c.Check(w.reused, Equals, false)
+	c.Check(w.concurrent.Load(), Equals, false)
+}
+
+func (s *AdaptiveSuite) TestIDPool(c *C) {
+	var ids idPool
+	c.Check(ids.acquire(), Equals, uint(0))
+	c.Check(ids.acquire(), Equals, uint(1))
+	c.Check(ids.acquire(), Equals, uint(2))
+	ids.release(2)
+	ids.release(0)
+	c.Check(ids.acquire(), Equals, uint(0))
+	c.Check(ids.acquire(), Equals, uint(2))
+	c.Check(ids.acquire(), Equals, uint(3))
+}
+
+func (s *AdaptiveSuite) TestClamp(c *C) {
+	ac := &AdaptiveConfig{MinWorkers: 2, MaxWorkers: 4}
+	c.Check(ac.clamp(0), Equals, uint(2))
+	c.Check(ac.clamp(3), Equals, uint(3))
+	c.Check(ac.clamp(10), Equals, uint(4))
+}
@@ -0,0 +1,355 @@
+package processing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+var ErrReducerMismatch = errors.New("processing: cannot merge reducers of different kinds or sizes")
+
+// Reducer summarizes a stream of values. Reducers of the same kind and size
+// can be merged, so that the summaries of several shards or runs can be
+// combined into one. Reducers are not safe for concurrent use.
+type Reducer interface {
+	Add(v interface{})
+	Merge(other Reducer) error
+	// Report returns the summary in a form that can be encoded as JSON.
+	Report() interface{}
+}
+
+// Counter is a Reducer that counts how often each distinct value occurs.
+// Values are compared by their fmt.Sprint representation.
+type Counter struct {
+	counts map[string]uint64
+}
+
+func NewCounter() *Counter {
+	return &Counter{counts: make(map[string]uint64)}
+}
+
+func (c *Counter) Add(v interface{}) {
+	c.counts[fmt.Sprint(v)]++
+}
+
+// Count returns how often value has occurred.
+func (c *Counter) Count(value string) uint64 {
+	return c.counts[value]
+}
+
+func (c *Counter) Merge(other Reducer) error {
+	o, ok := other.(*Counter)
+	if !ok {
+		return ErrReducerMismatch
+	}
+	for value, count := range o.counts {
+		c.counts[value] += count
+	}
+	return nil
+}
+
+func (c *Counter) Report() interface{} {
+	report := make(map[string]uint64, len(c.counts))
+	for value, count := range c.counts {
+		report[value] = count
+	}
+	return report
+}
+
+// HistogramBucket counts the values no greater than UpperBound and greater
+// than the previous bucket's. The last bucket has an infinite UpperBound,
+// which is encoded as the string "+Inf".
+type HistogramBucket struct {
+	UpperBound float64
+	Count      uint64
+}
+
+func (b HistogramBucket) MarshalJSON() ([]byte, error) {
+	le := strconv.FormatFloat(b.UpperBound, 'g', -1, 64)
+	if math.IsInf(b.UpperBound, 1) {
+		le = "+Inf"
+	}
+	return json.Marshal(struct {
+		UpperBound string `json:"le"`
+		Count      uint64 `json:"count"`
+	}{le, b.Count})
+}
+
+// HistogramReport is the summary reported by a Histogram.
+type HistogramReport struct {
+	Count   uint64            `json:"count"`
+	Sum     float64           `json:"sum"`
+	Min     float64           `json:"min"`
+	Max     float64           `json:"max"`
+	Invalid uint64            `json:"invalid"`
+	Buckets []HistogramBucket `json:"buckets"`
+}
+
+// Histogram is a Reducer that counts numeric values into buckets with fixed
+// upper bounds. Values that are not numbers, or strings holding a number,
+// are counted as invalid.
+type Histogram struct {
+	bounds  []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+	min     float64
+	max     float64
+	invalid uint64
+}
+
+// NewHistogram returns a Histogram with the given bucket upper bounds, which
+// are sorted. A final bucket for larger values is always added.
+func NewHistogram(bounds ...float64) *Histogram {
+	b := append([]float64(nil), bounds...)
+	sort.Float64s(b)
+	return &Histogram{
+		bounds: b,
+		counts: make([]uint64, len(b)+1),
+		min:    math.Inf(1),
+		max:    math.Inf(-1),
+	}
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func (h *Histogram) Add(v interface{}) {
+	f, ok := toFloat(v)
+	if !ok || math.IsNaN(f) {
+		h.invalid++
+		return
+	}
+	h.counts[sort.SearchFloat64s(h.bounds, f)]++
+	h.count++
+	h.sum += f
+	h.min = math.Min(h.min, f)
+	h.max = math.Max(h.max, f)
+}
+
+func (h *Histogram) Merge(other Reducer) error {
+	o, ok := other.(*Histogram)
+	if !ok || len(o.bounds) != len(h.bounds) {
+		return ErrReducerMismatch
+	}
+	for i, bound := range o.bounds {
+		if bound != h.bounds[i] {
+			return ErrReducerMismatch
+		}
+	}
+	for i, count := range o.counts {
+		h.counts[i] += count
+	}
+	h.count += o.count
+	h.sum += o.sum
+	h.min = math.Min(h.min, o.min)
+	h.max = math.Max(h.max, o.max)
+	h.invalid += o.invalid
+	return nil
+}
+
+func (h *Histogram) Report() interface{} {
+	report := HistogramReport{
+		Count:   h.count,
+		Sum:     h.sum,
+		Invalid: h.invalid,
+		Buckets: make([]HistogramBucket, len(h.counts)),
+	}
+	if h.count > 0 {
+		report.Min, report.Max = h.min, h.max
+	}
+	for i, count := range h.counts {
+		bound := math.Inf(1)
+		if i < len(h.bounds) {
+			bound = h.bounds[i]
+		}
+		report.Buckets[i] = HistogramBucket{UpperBound: bound, Count: count}
+	}
+	return report
+}
+
+type aggregate struct {
+	name    string
+	extract Extractor
+	reducer Reducer
+}
+
+// Aggregator is a BatchEncoder that feeds every record it is given to a set
+// of named reducers instead of writing it out. Used as the output of
+// Process, it summarizes a scan as it runs:
+//
+//	agg := NewTLSSummary("data.tls")
+//	agg.Output = os.Stdout
+//	Process(in, agg, w, workers)
+//
+// Close, which Process calls at the end of the run, writes the report to
+// Output if it is set. Failed records are not counted. Aggregator is safe
+// for concurrent use.
+type Aggregator struct {
+	Output io.Writer
+
+	mu         sync.Mutex
+	aggregates []aggregate
+	records    uint64
+}
+
+func NewAggregator() *Aggregator {
+	return new(Aggregator)
+}
+
+// Add registers reducer under name, to be fed every value extract returns.
+// It returns the Aggregator so that calls can be chained.
+func (a *Aggregator) Add(name string, extract Extractor, reducer Reducer) *Aggregator {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.aggregates = append(a.aggregates, aggregate{name: name, extract: extract, reducer: reducer})
+	return a
+}
+
+// Reducer returns the reducer registered under name, or nil.
+func (a *Aggregator) Reducer(name string) Reducer {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, agg := range a.aggregates {
+		if agg.name == name {
+			return agg.reducer
+		}
+	}
+	return nil
+}
+
+func (a *Aggregator) Encode(v interface{}) error {
+	return a.EncodeBatch([]interface{}{v})
+}
+
+func (a *Aggregator) EncodeBatch(vs []interface{}) error {
+	normalized := make([]interface{}, 0, len(vs))
+	for _, v := range vs {
+		if _, ok := v.(*RecordError); ok {
+			continue
+		}
+		n, err := normalize(v)
+		if err != nil {
+			return err
+		}
+		normalized = append(normalized, n)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, n := range normalized {
+		a.records++
+		for _, agg := range a.aggregates {
+			for _, value := range agg.extract(n) {
+				agg.reducer.Add(value)
+			}
+		}
+	}
+	return nil
+}
+
+// Merge adds the records and reducers of other into a. Every reducer of
+// other must have a counterpart of the same name in a.
+func (a *Aggregator) Merge(other *Aggregator) error {
+	other.mu.Lock()
+	defer other.mu.Unlock()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, o := range other.aggregates {
+		found := false
+		for _, agg := range a.aggregates {
+			if agg.name != o.name {
+				continue
+			}
+			if err := agg.reducer.Merge(o.reducer); err != nil {
+				return fmt.Errorf("processing: merging %s: %w", o.name, err)
+			}
+			found = true
+			break
+		}
+		if !found {
+			return fmt.Errorf("processing: merging %s: %w", o.name, ErrReducerMismatch)
+		}
+	}
+	a.records += other.records
+	return nil
+}
+
+// Report returns the number of records seen under "records" and the report
+// of every reducer under its name.
+func (a *Aggregator) Report() map[string]interface{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	report := map[string]interface{}{"records": a.records}
+	for _, agg := range a.aggregates {
+		report[agg.name] = agg.reducer.Report()
+	}
+	return report
+}
+
+// WriteReport writes the report to w as indented JSON.
+func (a *Aggregator) WriteReport(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(a.Report())
+}
+
+func (a *Aggregator) Flush() error {
+	return nil
+}
+
+func (a *Aggregator) Close() error {
+	if a.Output == nil {
+		return nil
+	}
+	return a.WriteReport(a.Output)
+}
+
+// NewTLSSummary returns an Aggregator for the usual per-scan TLS summary:
+// counts by protocol version, cipher suite, heartbeat support and validation
+// error, the most common certificate issuers, and the number of distinct
+// issuers. prefix is the field path of the handshake log within each
+// record, or empty if the records are handshake logs.
+func NewTLSSummary(prefix string) *Aggregator {
+	path := func(field string) Extractor {
+		if prefix == "" {
+			return FieldPath(field)
+		}
+		return FieldPath(prefix + "." + field)
+	}
+	return NewAggregator().
+		Add("tls_version", path("server_hello.version"), NewCounter()).
+		Add("cipher_suite", path("server_hello.cipher_suite"), NewCounter()).
+		Add("heartbeat", path("server_hello.heartbeat"), NewCounter()).
+		Add("validation_error", path("server_certificates.validation_error"), NewCounter()).
+		Add("issuer", path("server_certificates.issuer"), NewTopK(20, 0.0001, 0.001)).
+		Add("distinct_issuers", path("server_certificates.issuer"), NewHyperLogLog(14))
+}
@@ -0,0 +1,96 @@
+package processing
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type AggregateSuite struct{}
+
+var _ = Suite(&AggregateSuite{})
+
+func (s *AggregateSuite) TestFieldPath(c *C) {
+	var record interface{}
+	json.Unmarshal([]byte(`{"a":{"b":[{"c":1},{"c":2},{"d":3}]},"e":["x","y"],"n":null}`), &record)
+	c.Check(FieldPath("a.b.c")(record), DeepEquals, []interface{}{1.0, 2.0})
+	c.Check(FieldPath("e")(record), DeepEquals, []interface{}{"x", "y"})
+	c.Check(FieldPath("n")(record), HasLen, 0)
+	c.Check(FieldPath("missing.field")(record), HasLen, 0)
+	c.Check(FieldPath("port")(&host{IP: "1.2.3.4", Port: 443}), DeepEquals, []interface{}{443.0})
+	c.Check(FieldPath("ip")(map[string]string{"ip": "1.2.3.4"}), DeepEquals, []interface{}{"1.2.3.4"})
+}
+
+func (s *AggregateSuite) TestHistogram(c *C) {
+	h := NewHistogram(100, 10)
+	for _, v := range []interface{}{5.0, 10, "50", 1000.0, "x", nil} {
+		h.Add(v)
+	}
+	report := h.Report().(HistogramReport)
+	c.Check(report.Count, Equals, uint64(4))
+	c.Check(report.Invalid, Equals, uint64(2))
+	c.Check(report.Sum, Equals, 1065.0)
+	c.Check(report.Min, Equals, 5.0)
+	c.Check(report.Max, Equals, 1000.0)
+	b, err := json.Marshal(report.Buckets)
+	c.Assert(err, IsNil)
+	c.Check(string(b), Equals, `[{"le":"10","count":2},{"le":"100","count":1},{"le":"+Inf","count":1}]`)
+
+	other := NewHistogram(10, 100)
+	other.Add(1)
+	c.Assert(h.Merge(other), IsNil)
+	c.Check(h.Report().(HistogramReport).Min, Equals, 1.0)
+	c.Check(h.Merge(NewHistogram(10)), Equals, ErrReducerMismatch)
+}
+
+func (s *AggregateSuite) TestTLSSummary(c *C) {
+	in := `{"ip":"1.1.1.1","data":{"tls":{"server_hello":{"version":771,"cipher_suite":49199,"heartbeat":true},"server_certificates":{"valid":true,"issuer":"CA One"}}}}
+{"ip":"2.2.2.2","data":{"tls":{"server_hello":{"version":771,"cipher_suite":49195,"heartbeat":false},"server_certificates":{"valid":false,"validation_error":"x509: expired","issuer":"CA Two"}}}}
+{"ip":"3.3.3.3","data":{"tls":{"server_hello":{"version":769,"cipher_suite":49199,"heartbeat":false},"server_certificates":{"valid":true,"issuer":"CA One"}}}}
+{"ip":"4.4.4.4"}
+`
+	agg := NewTLSSummary("data.tls")
+	var report bytes.Buffer
+	agg.Output = &report
+	w := &testWorker{handler: func(v interface{}) interface{} {
+		if v.(map[string]interface{})["ip"] == "4.4.4.4" {
+			return Fail(errors.New("no handshake"))
+		}
+		return v
+	}}
+	Process(NewJSONLinesDecoder(strings.NewReader(in)), agg, w, 2)
+
+	var got map[string]interface{}
+	c.Assert(json.Unmarshal(report.Bytes(), &got), IsNil)
+	c.Check(got["records"], Equals, 3.0)
+	c.Check(got["tls_version"], DeepEquals, map[string]interface{}{"771": 2.0, "769": 1.0})
+	c.Check(got["cipher_suite"], DeepEquals, map[string]interface{}{"49199": 2.0, "49195": 1.0})
+	c.Check(got["heartbeat"], DeepEquals, map[string]interface{}{"true": 1.0, "false": 2.0})
+	c.Check(got["validation_error"], DeepEquals, map[string]interface{}{"x509: expired": 1.0})
+	c.Check(got["issuer"], DeepEquals, []interface{}{
+		map[string]interface{}{"value": "CA One", "count": 2.0},
+		map[string]interface{}{"value": "CA Two", "count": 1.0},
+	})
+	c.Check(got["distinct_issuers"], Equals, 2.0)
+}
+
+func (s *AggregateSuite) TestMerge(c *C) {
+	shard := func(values ...string) *Aggregator {
+		agg := NewAggregator().Add("v", FieldPath("v"), NewCounter())
+		for _, v := range values {
+			c.Assert(agg.Encode(map[string]interface{}{"v": v}), IsNil)
+		}
+		return agg
+	}
+	a := shard("x", "y")
+	c.Assert(a.Merge(shard("x", "z")), IsNil)
+	c.Check(a.Report(), DeepEquals, map[string]interface{}{
+		"records": uint64(4),
+		"v":       map[string]uint64{"x": 2, "y": 1, "z": 1},
+	})
+	other := NewAggregator().Add("w", FieldPath("w"), NewCounter())
+	c.Check(errors.Is(a.Merge(other), ErrReducerMismatch), Equals, true)
+}
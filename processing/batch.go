@@ -0,0 +1,20 @@
+package processing
+
+// BatchEncoder is an Encoder that Process writes results to in batches. At
+// the end of a run, whether it completes, fails or is cancelled, Process
+// writes any partial batch and then calls Flush and Close, so that buffered
+// output is not lost.
+type BatchEncoder interface {
+	Encoder
+	EncodeBatch(vs []interface{}) error
+	Flush() error
+	Close() error
+}
+
+// TypedBatchEncoder is the type-safe counterpart of BatchEncoder.
+type TypedBatchEncoder[Out any] interface {
+	TypedEncoder[Out]
+	EncodeBatch(vs []Out) error
+	Flush() error
+	Close() error
+}
@@ -0,0 +1,97 @@
+package processing
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type BatchSuite struct{}
+
+var _ = Suite(&BatchSuite{})
+
+type batchRecorder struct {
+	batches         [][]interface{}
+	flushed, closed bool
+}
+
+func (b *batchRecorder) Encode(v interface{}) error {
+	return errors.New("Encode called on batch encoder")
+}
+
+func (b *batchRecorder) EncodeBatch(vs []interface{}) error {
+	batch := make([]interface{}, len(vs))
+	copy(batch, vs)
+	b.batches = append(b.batches, batch)
+	return nil
+}
+
+func (b *batchRecorder) Flush() error {
+	b.flushed = true
+	return nil
+}
+
+func (b *batchRecorder) Close() error {
+	b.closed = true
+	return nil
+}
+
+func (s *BatchSuite) TestBatches(c *C) {
+	out := new(batchRecorder)
+	config := &Config{Ordered: true, BatchSize: 4}
+	err := ProcessConfig(context.Background(), &sliceDecoder{values: intRange(10)}, out, new(testWorker), 2, config)
+	c.Assert(err, IsNil)
+	c.Check(out.batches, DeepEquals, [][]interface{}{
+		{0, 1, 2, 3}, {4, 5, 6, 7}, {8, 9},
+	})
+	c.Check(out.flushed, Equals, true)
+	c.Check(out.closed, Equals, true)
+}
+
+func (s *BatchSuite) TestBatchLatency(c *C) {
+	out := new(batchRecorder)
+	in := &sliceDecoder{values: intRange(3)}
+	w := &testWorker{handler: func(v interface{}) interface{} {
+		if v.(int) == 2 {
+			time.Sleep(50 * time.Millisecond)
+		}
+		return v
+	}}
+	config := &Config{Ordered: true, BatchSize: 100, BatchLatency: 5 * time.Millisecond}
+	c.Assert(ProcessConfig(context.Background(), in, out, w, 1, config), IsNil)
+	c.Assert(len(out.batches) >= 2, Equals, true)
+	c.Check(out.batches[0], DeepEquals, []interface{}{0, 1})
+}
+
+func (s *BatchSuite) TestCloseOnCancel(c *C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	out := new(batchRecorder)
+	w := &testWorker{handler: func(v interface{}) interface{} {
+		if v.(int) == 5 {
+			cancel()
+		}
+		return v
+	}}
+	err := ProcessConfig(ctx, &sliceDecoder{values: intRange(100)}, out, w, 1, &Config{BatchSize: 1000})
+	c.Check(err, Equals, context.Canceled)
+	c.Assert(out.batches, HasLen, 1)
+	c.Check(len(out.batches[0]) >= 5, Equals, true)
+	c.Check(out.flushed, Equals, true)
+	c.Check(out.closed, Equals, true)
+}
+
+func (s *BatchSuite) TestFileEncoder(c *C) {
+	path := filepath.Join(c.MkDir(), "out.json")
+	f, err := CreateJSONLinesFile(path)
+	c.Assert(err, IsNil)
+	err = ProcessConfig(context.Background(), &sliceDecoder{values: intRange(3)}, f, new(testWorker), 1, &Config{Ordered: true})
+	c.Assert(err, IsNil)
+	b, err := os.ReadFile(path)
+	c.Assert(err, IsNil)
+	c.Check(strings.Split(string(b), "\n"), DeepEquals, []string{"0", "1", "2", ""})
+}
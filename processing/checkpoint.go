@@ -0,0 +1,129 @@
+package processing
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultCheckpointInterval is used when CheckpointConfig.Interval is zero.
+const DefaultCheckpointInterval = time.Minute
+
+var (
+	ErrResumeWithoutSync = errors.New("processing: resuming from a checkpoint requires CheckpointConfig.Sync")
+	ErrNoOutputOffset    = errors.New("processing: checkpoint has no output offset to resume from")
+)
+
+// CheckpointConfig configures checkpointing for ProcessConfig.
+type CheckpointConfig struct {
+	// Path is the sidecar file checkpoints are written to.
+	Path string
+	// Interval is the time between checkpoints. A final checkpoint is
+	// always written when the run ends.
+	Interval time.Duration
+	// Resume skips the input records counted by the checkpoint at Path, if
+	// one exists. It requires Sync, and fails with ErrNoOutputOffset if the
+	// checkpoint was written without one.
+	Resume bool
+	// Sync, if set, is called before every checkpoint, after the encoder has
+	// been flushed. It should make the output durable and return its length,
+	// which is recorded as the checkpoint's Offset. Without it, the offset is
+	// unknown and the checkpoint cannot be resumed from.
+	Sync func() (int64, error)
+}
+
+func (cc *CheckpointConfig) interval() time.Duration {
+	if cc.Interval <= 0 {
+		return DefaultCheckpointInterval
+	}
+	return cc.Interval
+}
+
+// Checkpoint records the progress of a run: the first Records input records
+// have been handled and their results encoded, and the output was Offset
+// bytes long at that point. Offset is -1 if it is unknown. Only the main
+// output is covered: failed records that were written to Config.DeadLetter
+// after the checkpoint are written to it again by a resumed run.
+type Checkpoint struct {
+	Records uint64    `json:"records"`
+	Offset  int64     `json:"output_offset"`
+	Time    time.Time `json:"time"`
+}
+
+// flusher is implemented by encoders that buffer output.
+type flusher interface {
+	Flush() error
+}
+
+// LoadCheckpoint reads the checkpoint at path. A missing file yields an
+// empty checkpoint.
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return new(Checkpoint), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cp := new(Checkpoint)
+	if err := json.Unmarshal(b, cp); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// Save atomically replaces the checkpoint at path.
+func (cp *Checkpoint) Save(path string) error {
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ResumeOutput opens the named output file for a resumed run, positioned at
+// its end. Anything written after the output offset of cp is discarded so
+// that results encoded after the checkpoint are not duplicated; an empty
+// checkpoint discards the whole file. A checkpoint of a run that had
+// progressed without recording an offset fails with ErrNoOutputOffset.
+func ResumeOutput(name string, cp *Checkpoint) (*os.File, error) {
+	if cp.Records > 0 && cp.Offset < 0 {
+		return nil, ErrNoOutputOffset
+	}
+	offset := cp.Offset
+	if offset < 0 {
+		offset = 0
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(offset); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,165 @@
+package processing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type CheckpointSuite struct{}
+
+var _ = Suite(&CheckpointSuite{})
+
+func (s *CheckpointSuite) TestLoadMissing(c *C) {
+	cp, err := LoadCheckpoint(filepath.Join(c.MkDir(), "missing"))
+	c.Assert(err, IsNil)
+	c.Check(cp.Records, Equals, uint64(0))
+}
+
+func (s *CheckpointSuite) TestSaveLoad(c *C) {
+	path := filepath.Join(c.MkDir(), "run.checkpoint")
+	c.Assert((&Checkpoint{Records: 42, Offset: 1000}).Save(path), IsNil)
+	cp, err := LoadCheckpoint(path)
+	c.Assert(err, IsNil)
+	c.Check(cp.Records, Equals, uint64(42))
+	c.Check(cp.Offset, Equals, int64(1000))
+}
+
+func runCheckpointed(c *C, dir string, in Decoder) error {
+	cc := &CheckpointConfig{
+		Path:   filepath.Join(dir, "out.checkpoint"),
+		Resume: true,
+	}
+	cp, err := LoadCheckpoint(cc.Path)
+	c.Assert(err, IsNil)
+	f, err := ResumeOutput(filepath.Join(dir, "out.json"), cp)
+	c.Assert(err, IsNil)
+	defer f.Close()
+	cc.Sync = func() (int64, error) {
+		if err := f.Sync(); err != nil {
+			return 0, err
+		}
+		return f.Seek(0, io.SeekCurrent)
+	}
+	w := &testWorker{handler: func(v interface{}) interface{} {
+		return v
+	}}
+	config := &Config{Checkpoint: cc, ReorderWindow: 8}
+	return ProcessConfig(context.Background(), in, NewJSONLinesEncoder(f), w, 4, config)
+}
+
+func (s *CheckpointSuite) TestResume(c *C) {
+	dir := c.MkDir()
+	crash := errors.New("crash")
+
+	in := &sliceDecoder{values: intRange(60), err: crash}
+	c.Assert(runCheckpointed(c, dir, in), Equals, crash)
+	cp, err := LoadCheckpoint(filepath.Join(dir, "out.checkpoint"))
+	c.Assert(err, IsNil)
+	c.Check(cp.Records <= 60, Equals, true)
+
+	in = &sliceDecoder{values: intRange(100)}
+	c.Assert(runCheckpointed(c, dir, in), IsNil)
+
+	b, err := os.ReadFile(filepath.Join(dir, "out.json"))
+	c.Assert(err, IsNil)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	c.Assert(lines, HasLen, 100)
+	for i, line := range lines {
+		c.Assert(line, Equals, strconv.Itoa(i))
+	}
+	cp, err = LoadCheckpoint(filepath.Join(dir, "out.checkpoint"))
+	c.Assert(err, IsNil)
+	c.Check(cp.Records, Equals, uint64(100))
+	c.Check(cp.Offset, Equals, int64(len(b)))
+}
+
+func (s *CheckpointSuite) TestResumeWithoutSync(c *C) {
+	cc := &CheckpointConfig{Path: filepath.Join(c.MkDir(), "out.checkpoint"), Resume: true}
+	in := &sliceDecoder{values: intRange(10)}
+	err := ProcessConfig(context.Background(), in, new(sliceEncoder), new(testWorker), 2, &Config{Checkpoint: cc})
+	c.Check(err, Equals, ErrResumeWithoutSync)
+}
+
+func (s *CheckpointSuite) TestResumeUnknownOffset(c *C) {
+	dir := c.MkDir()
+	cc := &CheckpointConfig{Path: filepath.Join(dir, "out.checkpoint")}
+	in := &sliceDecoder{values: intRange(10)}
+	c.Assert(ProcessConfig(context.Background(), in, new(sliceEncoder), new(testWorker), 2, &Config{Checkpoint: cc}), IsNil)
+	cp, err := LoadCheckpoint(cc.Path)
+	c.Assert(err, IsNil)
+	c.Check(cp.Records, Equals, uint64(10))
+	c.Check(cp.Offset, Equals, int64(-1))
+
+	_, err = ResumeOutput(filepath.Join(dir, "out.json"), cp)
+	c.Check(err, Equals, ErrNoOutputOffset)
+}
+
+func (s *CheckpointSuite) TestNoCheckpointAfterFailure(c *C) {
+	crash := errors.New("crash")
+	cc := &CheckpointConfig{Path: filepath.Join(c.MkDir(), "out.checkpoint")}
+	in := &sliceDecoder{values: intRange(10)}
+	out := &sliceEncoder{err: crash}
+	c.Assert(ProcessConfig(context.Background(), in, out, new(testWorker), 2, &Config{Checkpoint: cc}), Equals, crash)
+	_, err := os.Stat(cc.Path)
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func runCheckpointedGzip(c *C, dir string, in Decoder) error {
+	name := filepath.Join(dir, "out.json.gz")
+	cc := &CheckpointConfig{
+		Path:   filepath.Join(dir, "out.checkpoint"),
+		Resume: true,
+		Sync: func() (int64, error) {
+			info, err := os.Stat(name)
+			if err != nil {
+				return 0, err
+			}
+			return info.Size(), nil
+		},
+	}
+	cp, err := LoadCheckpoint(cc.Path)
+	c.Assert(err, IsNil)
+	out, err := ResumeJSONLinesFile(name, cp)
+	c.Assert(err, IsNil)
+	config := &Config{Checkpoint: cc, ReorderWindow: 8}
+	return ProcessConfig(context.Background(), in, out, new(testWorker), 4, config)
+}
+
+func (s *CheckpointSuite) TestResumeGzip(c *C) {
+	dir := c.MkDir()
+	crash := errors.New("crash")
+
+	in := &sliceDecoder{values: intRange(60), err: crash}
+	c.Assert(runCheckpointedGzip(c, dir, in), Equals, crash)
+	cp, err := LoadCheckpoint(filepath.Join(dir, "out.checkpoint"))
+	c.Assert(err, IsNil)
+	c.Check(cp.Records <= 60, Equals, true)
+
+	in = &sliceDecoder{values: intRange(100)}
+	c.Assert(runCheckpointedGzip(c, dir, in), IsNil)
+
+	r, err := OpenInput(filepath.Join(dir, "out.json.gz"))
+	c.Assert(err, IsNil)
+	b, err := io.ReadAll(r)
+	c.Assert(err, IsNil)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	c.Assert(lines, HasLen, 100)
+	for i, line := range lines {
+		c.Assert(line, Equals, strconv.Itoa(i))
+	}
+	cp, err = LoadCheckpoint(filepath.Join(dir, "out.checkpoint"))
+	c.Assert(err, IsNil)
+	c.Check(cp.Records, Equals, uint64(100))
+}
+
+func (s *CheckpointSuite) TestResumeZstdUnsupported(c *C) {
+	_, err := ResumeJSONLinesFile(filepath.Join(c.MkDir(), "out.json.zst"), new(Checkpoint))
+	c.Check(err, Equals, ErrUnsupportedCompression)
+}
@@ -0,0 +1,229 @@
+package processing
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+type Compression uint8
+
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+	CompressionZstd
+	CompressionBzip2
+	CompressionXZ
+)
+
+var (
+	ErrUnsupportedCompression = errors.New("processing: unsupported output compression")
+	ErrWriterClosed           = errors.New("processing: write to closed writer")
+)
+
+var (
+	magicGzip  = []byte{0x1f, 0x8b}
+	magicZstd  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	magicBzip2 = []byte("BZh")
+	magicXZ    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+var compressionNames = []string{"none", "gzip", "zstd", "bzip2", "xz"}
+
+func (c Compression) String() string {
+	if int(c) >= len(compressionNames) {
+		return "unknown"
+	}
+	return compressionNames[c]
+}
+
+// DetectCompression identifies the compression format of a stream from its
+// leading bytes.
+func DetectCompression(header []byte) Compression {
+	switch {
+	case bytes.HasPrefix(header, magicGzip):
+		return CompressionGzip
+	case bytes.HasPrefix(header, magicZstd):
+		return CompressionZstd
+	case bytes.HasPrefix(header, magicBzip2):
+		return CompressionBzip2
+	case bytes.HasPrefix(header, magicXZ):
+		return CompressionXZ
+	}
+	return CompressionNone
+}
+
+// CompressionForName selects a compression format from a file extension.
+func CompressionForName(name string) Compression {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".gz", ".gzip":
+		return CompressionGzip
+	case ".zst", ".zstd":
+		return CompressionZstd
+	case ".bz2":
+		return CompressionBzip2
+	case ".xz":
+		return CompressionXZ
+	}
+	return CompressionNone
+}
+
+type multiCloser struct {
+	io.Reader
+	closers []func() error
+}
+
+func (m *multiCloser) Close() error {
+	var err error
+	for _, c := range m.closers {
+		if cerr := c(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// NewDecompressReader returns a reader that transparently decompresses r if
+// it starts with the magic bytes of a supported format. Closing the returned
+// reader also closes r when r is an io.Closer.
+func NewDecompressReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	header, _ := br.Peek(len(magicXZ))
+	rc := &multiCloser{Reader: br}
+	switch DetectCompression(header) {
+	case CompressionGzip:
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		rc.Reader = gz
+		rc.closers = append(rc.closers, gz.Close)
+	case CompressionZstd:
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		rc.Reader = zr
+		rc.closers = append(rc.closers, func() error {
+			zr.Close()
+			return nil
+		})
+	case CompressionBzip2:
+		rc.Reader = bzip2.NewReader(br)
+	case CompressionXZ:
+		xr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		rc.Reader = xr
+	}
+	if c, ok := r.(io.Closer); ok {
+		rc.closers = append(rc.closers, c.Close)
+	}
+	return rc, nil
+}
+
+// NewCompressWriter wraps w in a compressor for the given format. Closing the
+// returned writer flushes the compressor but does not close w. Bzip2 is
+// supported for input only, and returns ErrUnsupportedCompression.
+func NewCompressWriter(w io.Writer, c Compression) (io.WriteCloser, error) {
+	switch c {
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	case CompressionGzip:
+		return NewParallelGzipWriter(w, gzip.DefaultCompression, 0), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	case CompressionXZ:
+		return xz.NewWriter(w)
+	}
+	return nil, ErrUnsupportedCompression
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// OpenInput opens the named file, or standard input for "-", and
+// decompresses it according to its contents.
+func OpenInput(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return NewDecompressReader(io.NopCloser(os.Stdin))
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := NewDecompressReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return rc, nil
+}
+
+type compressedFile struct {
+	io.WriteCloser
+	f      *os.File
+	closed bool
+}
+
+// Flush writes out the data buffered by the compressor, if it can, such as
+// a ParallelGzipWriter or a zstd encoder.
+func (c *compressedFile) Flush() error {
+	if f, ok := c.WriteCloser.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Close closes the compressor and then the file. Closing it again has no
+// effect.
+func (c *compressedFile) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	err := c.WriteCloser.Close()
+	if cerr := c.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// CreateOutput creates the named file, or uses standard output for "-", and
+// compresses everything written to it according to the file extension.
+// Bzip2 is supported for input only, so a .bz2 name returns
+// ErrUnsupportedCompression without creating the file.
+func CreateOutput(name string) (io.WriteCloser, error) {
+	if name == "-" {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	c := CompressionForName(name)
+	if c == CompressionBzip2 {
+		return nil, ErrUnsupportedCompression
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewCompressWriter(f, c)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &compressedFile{WriteCloser: w, f: f}, nil
+}
@@ -0,0 +1,127 @@
+package processing
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+type CompressSuite struct{}
+
+var _ = Suite(&CompressSuite{})
+
+func (s *CompressSuite) TestDetectCompression(c *C) {
+	c.Check(DetectCompression([]byte{0x1f, 0x8b, 0x08}), Equals, CompressionGzip)
+	c.Check(DetectCompression([]byte{0x28, 0xb5, 0x2f, 0xfd}), Equals, CompressionZstd)
+	c.Check(DetectCompression([]byte("BZh91AY")), Equals, CompressionBzip2)
+	c.Check(DetectCompression([]byte{0xfd, '7', 'z', 'X', 'Z', 0x00}), Equals, CompressionXZ)
+	c.Check(DetectCompression([]byte("{\"ip\"")), Equals, CompressionNone)
+	c.Check(DetectCompression(nil), Equals, CompressionNone)
+}
+
+func (s *CompressSuite) TestCompressionForName(c *C) {
+	c.Check(CompressionForName("out.json.gz"), Equals, CompressionGzip)
+	c.Check(CompressionForName("out.json.zst"), Equals, CompressionZstd)
+	c.Check(CompressionForName("out.json.XZ"), Equals, CompressionXZ)
+	c.Check(CompressionForName("out.json"), Equals, CompressionNone)
+}
+
+func testPayload() []byte {
+	var buf bytes.Buffer
+	for i := 0; buf.Len() < 3*DefaultGzipBlockSize+123; i++ {
+		buf.WriteString("{\"ip\":\"10.0.0.1\",\"port\":443,\"seq\":")
+		buf.WriteString(string(rune('a' + i%26)))
+		buf.WriteString("}\n")
+	}
+	return buf.Bytes()
+}
+
+func (s *CompressSuite) TestParallelGzip(c *C) {
+	payload := testPayload()
+	var buf bytes.Buffer
+	z := NewParallelGzipWriter(&buf, gzip.BestSpeed, 3)
+	for p := payload; len(p) > 0; {
+		n := 4096
+		if n > len(p) {
+			n = len(p)
+		}
+		_, err := z.Write(p[:n])
+		c.Assert(err, IsNil)
+		p = p[n:]
+	}
+	c.Assert(z.Close(), IsNil)
+
+	r, err := gzip.NewReader(&buf)
+	c.Assert(err, IsNil)
+	got, err := io.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Check(bytes.Equal(got, payload), Equals, true)
+}
+
+func (s *CompressSuite) TestParallelGzipEmpty(c *C) {
+	var buf bytes.Buffer
+	c.Assert(NewParallelGzipWriter(&buf, gzip.DefaultCompression, 0).Close(), IsNil)
+	r, err := gzip.NewReader(&buf)
+	c.Assert(err, IsNil)
+	got, err := io.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Check(got, HasLen, 0)
+}
+
+func (s *CompressSuite) TestParallelGzipShort(c *C) {
+	var buf bytes.Buffer
+	z := NewParallelGzipWriter(&buf, gzip.DefaultCompression, 0)
+	_, err := z.Write([]byte("short"))
+	c.Assert(err, IsNil)
+	c.Assert(z.Close(), IsNil)
+	r, err := gzip.NewReader(&buf)
+	c.Assert(err, IsNil)
+	got, err := io.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Check(string(got), Equals, "short")
+}
+
+func (s *CompressSuite) TestParallelGzipCloseTwice(c *C) {
+	var buf bytes.Buffer
+	z := NewParallelGzipWriter(&buf, gzip.DefaultCompression, 0)
+	_, err := z.Write([]byte("short"))
+	c.Assert(err, IsNil)
+	c.Assert(z.Close(), IsNil)
+	c.Assert(z.Close(), IsNil)
+	_, err = z.Write([]byte("more"))
+	c.Check(err, Equals, ErrWriterClosed)
+}
+
+func (s *CompressSuite) TestRoundTrip(c *C) {
+	payload := testPayload()
+	dir := c.MkDir()
+	for _, name := range []string{"out.json", "out.json.gz", "out.json.zst", "out.json.xz"} {
+		path := filepath.Join(dir, name)
+		w, err := CreateOutput(path)
+		c.Assert(err, IsNil)
+		_, err = w.Write(payload)
+		c.Assert(err, IsNil)
+		c.Assert(w.Close(), IsNil)
+		c.Assert(w.Close(), IsNil)
+
+		raw, err := os.ReadFile(path)
+		c.Assert(err, IsNil)
+		c.Check(DetectCompression(raw), Equals, CompressionForName(name), Commentf(name))
+
+		r, err := OpenInput(path)
+		c.Assert(err, IsNil)
+		got, err := io.ReadAll(r)
+		c.Assert(err, IsNil)
+		c.Assert(r.Close(), IsNil)
+		c.Check(bytes.Equal(got, payload), Equals, true, Commentf(name))
+	}
+}
+
+func (s *CompressSuite) TestBzip2OutputUnsupported(c *C) {
+	_, err := CreateOutput(filepath.Join(c.MkDir(), "out.json.bz2"))
+	c.Check(err, Equals, ErrUnsupportedCompression)
+}
@@ -0,0 +1,149 @@
+package processing
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// CSVDecoder decodes CSV input with a header line, such as the output of
+// ZMap's csv module with --output-fields, into map[string]string records
+// keyed by column name. A row with the wrong number of fields, or one that
+// cannot be parsed, yields a *MalformedRecordError.
+type CSVDecoder struct {
+	r      *csv.Reader
+	header []string
+}
+
+func NewCSVDecoder(r io.Reader) *CSVDecoder {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	return &CSVDecoder{r: cr}
+}
+
+// Header returns the column names, reading the header line if no record has
+// been decoded yet.
+func (d *CSVDecoder) Header() ([]string, error) {
+	if d.header != nil {
+		return d.header, nil
+	}
+	header, err := d.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	d.header = make([]string, len(header))
+	copy(d.header, header)
+	return d.header, nil
+}
+
+func (d *CSVDecoder) DecodeNext() (interface{}, error) {
+	header, err := d.Header()
+	if err != nil {
+		return nil, err
+	}
+	fields, err := d.r.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, &MalformedRecordError{Line: parseErr.Line, Err: parseErr.Err}
+		}
+		return nil, err
+	}
+	if len(fields) != len(header) {
+		line, _ := d.r.FieldPos(0)
+		return nil, &MalformedRecordError{
+			Line: line,
+			Err:  fmt.Errorf("expected %d fields, got %d", len(header), len(fields)),
+		}
+	}
+	m := make(map[string]string, len(header))
+	for i, name := range header {
+		m[name] = fields[i]
+	}
+	return m, nil
+}
+
+// CSVEncoder writes rows of CSV output, such as the rows of a Projection,
+// after a header line. Encode accepts a []string row, a [][]string of
+// several rows, or a map[string]string record, whose fields are written in
+// header order. Output is buffered until Flush or Close, which Process calls
+// at the end of a run; Close does not close the underlying writer. It
+// cannot encode failed records, so runs writing to it should set
+// Config.DeadLetter.
+type CSVEncoder struct {
+	w           *csv.Writer
+	header      []string
+	wroteHeader bool
+}
+
+// NewCSVEncoder returns an encoder that writes header, unless it is nil,
+// before the first row.
+func NewCSVEncoder(w io.Writer, header []string) *CSVEncoder {
+	return &CSVEncoder{w: csv.NewWriter(w), header: header}
+}
+
+// NewTSVEncoder returns a CSVEncoder that separates fields by tabs.
+func NewTSVEncoder(w io.Writer, header []string) *CSVEncoder {
+	e := NewCSVEncoder(w, header)
+	e.w.Comma = '\t'
+	return e
+}
+
+func (e *CSVEncoder) writeHeader() error {
+	if e.wroteHeader || e.header == nil {
+		return nil
+	}
+	e.wroteHeader = true
+	return e.w.Write(e.header)
+}
+
+func (e *CSVEncoder) Encode(v interface{}) error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	switch row := v.(type) {
+	case []string:
+		return e.w.Write(row)
+	case [][]string:
+		for _, r := range row {
+			if err := e.w.Write(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]string:
+		if e.header == nil {
+			return errors.New("processing: cannot encode a record as CSV without a header")
+		}
+		fields := make([]string, len(e.header))
+		for i, name := range e.header {
+			fields[i] = row[name]
+		}
+		return e.w.Write(fields)
+	}
+	return fmt.Errorf("processing: cannot encode %T as CSV", v)
+}
+
+func (e *CSVEncoder) EncodeBatch(vs []interface{}) error {
+	for _, v := range vs {
+		if err := e.Encode(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *CSVEncoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// Close writes the header if no row was written, and flushes the output.
+func (e *CSVEncoder) Close() error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	return e.Flush()
+}
@@ -0,0 +1,48 @@
+package processing
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *CodecSuite) TestCSVDecoder(c *C) {
+	in := "saddr,sport\n1.2.3.4,443\n5.6.7.8\n9.9.9.9,80\n"
+	d := NewCSVDecoder(strings.NewReader(in))
+
+	v, err := d.DecodeNext()
+	c.Assert(err, IsNil)
+	c.Check(v, DeepEquals, map[string]string{"saddr": "1.2.3.4", "sport": "443"})
+
+	_, err = d.DecodeNext()
+	var malformed *MalformedRecordError
+	c.Assert(errors.As(err, &malformed), Equals, true)
+	c.Check(malformed.Line, Equals, 3)
+
+	v, err = d.DecodeNext()
+	c.Assert(err, IsNil)
+	c.Check(v, DeepEquals, map[string]string{"saddr": "9.9.9.9", "sport": "80"})
+
+	_, err = d.DecodeNext()
+	c.Check(err, Equals, io.EOF)
+}
+
+func (s *CodecSuite) TestCSVEncoder(c *C) {
+	var out bytes.Buffer
+	e := NewCSVEncoder(&out, []string{"ip", "name"})
+	c.Assert(e.Encode([]string{"1.2.3.4", "a,b"}), IsNil)
+	c.Assert(e.Encode([][]string{{"5.6.7.8", ""}, {"9.9.9.9", "c"}}), IsNil)
+	c.Assert(e.Encode(map[string]string{"name": "d", "ip": "1.1.1.1"}), IsNil)
+	c.Check(e.Encode(42), NotNil)
+	c.Check(out.String(), Equals, "")
+	c.Assert(e.Close(), IsNil)
+	c.Check(out.String(), Equals, "ip,name\n1.2.3.4,\"a,b\"\n5.6.7.8,\n9.9.9.9,c\n1.1.1.1,d\n")
+
+	out.Reset()
+	e = NewTSVEncoder(&out, []string{"ip", "name"})
+	c.Assert(e.Close(), IsNil)
+	c.Check(out.String(), Equals, "ip\tname\n")
+}
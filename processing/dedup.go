@@ -0,0 +1,150 @@
+package processing
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// KeySet remembers the keys of the records seen so far. Add reports whether
+// key was new, and must be safe for concurrent use.
+type KeySet interface {
+	Add(key []byte) bool
+}
+
+type exactKeySet struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+// NewExactKeySet returns a KeySet that stores every key in memory.
+func NewExactKeySet() KeySet {
+	return &exactKeySet{keys: make(map[string]struct{})}
+}
+
+func (s *exactKeySet) Add(key []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[string(key)]; ok {
+		return false
+	}
+	s.keys[string(key)] = struct{}{}
+	return true
+}
+
+// BloomFilter is a KeySet of fixed size. Add never reports a previously
+// added key as new, but may report a new key as seen with the false
+// positive rate the filter was sized for. Concurrent Adds of the same key
+// are serialized by a lock chosen by the key's hash, so that only one of
+// them reports it as new.
+type BloomFilter struct {
+	bits   []uint64
+	m      uint64
+	hashes uint64
+	locks  [bloomStripes]sync.Mutex
+}
+
+// bloomStripes is the number of locks a BloomFilter spreads its keys over.
+const bloomStripes = 64
+
+// NewBloomFilter returns a BloomFilter sized to hold n keys with the given
+// false positive rate.
+func NewBloomFilter(n uint64, falsePositiveRate float64) *BloomFilter {
+	if n == 0 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k == 0 {
+		k = 1
+	}
+	return &BloomFilter{
+		bits:   make([]uint64, (m+63)/64),
+		m:      m,
+		hashes: k,
+	}
+}
+
+// hashPair returns two hashes of key, to be combined by double
+// hashing. The second is a remix of the first by the splitmix64 finalizer,
+// and is odd.
+func hashPair(key []byte) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write(key)
+	h1 := h.Sum64()
+	h2 := h1
+	h2 ^= h2 >> 30
+	h2 *= 0xbf58476d1ce4e5b9
+	h2 ^= h2 >> 27
+	h2 *= 0x94d049bb133111eb
+	h2 ^= h2 >> 31
+	return h1, h2 | 1
+}
+
+func (b *BloomFilter) Add(key []byte) bool {
+	h1, h2 := hashPair(key)
+	// Bits are shared between keys of different stripes, so they are still
+	// set atomically.
+	mu := &b.locks[h1%bloomStripes]
+	mu.Lock()
+	defer mu.Unlock()
+	added := false
+	for i := uint64(0); i < b.hashes; i++ {
+		bit := (h1 + i*h2) % b.m
+		mask := uint64(1) << (bit % 64)
+		if atomic.OrUint64(&b.bits[bit/64], mask)&mask == 0 {
+			added = true
+		}
+	}
+	return added
+}
+
+// DedupWorker wraps a Worker so that records whose key has already been seen
+// are skipped before they reach the wrapped Worker's handlers. Records for
+// which key returns nil are never skipped. Dropped records are counted by
+// Duplicates and included in Total.
+type DedupWorker struct {
+	wrappedWorker
+	key        func(interface{}) []byte
+	seen       KeySet
+	duplicates atomic.Uint64
+}
+
+func NewDedupWorker(w Worker, key func(interface{}) []byte, seen KeySet) *DedupWorker {
+	d := &DedupWorker{
+		key:  key,
+		seen: seen,
+	}
+	d.wrappedWorker = wrapWorker(w, func(handler Handler) Handler {
+		return func(v interface{}) interface{} {
+			if key := d.key(v); key != nil && !d.seen.Add(key) {
+				d.duplicates.Add(1)
+				return Skip
+			}
+			return handler(v)
+		}
+	})
+	return d
+}
+
+// Duplicates returns the number of records dropped as duplicates.
+func (d *DedupWorker) Duplicates() uint {
+	return uint(d.duplicates.Load())
+}
+
+func (d *DedupWorker) Total() uint {
+	return d.Worker.Total() + d.Duplicates()
+}
+
+// Dedup starts a flow stage that drops records whose key has already been
+// seen.
+func Dedup[T any](s *Stream[T], key func(T) []byte, seen KeySet) *Stream[T] {
+	return Filter(s, StageOptions{}, func(v T) (bool, error) {
+		k := key(v)
+		return k == nil || seen.Add(k), nil
+	})
+}
@@ -0,0 +1,78 @@
+package processing
+
+import (
+	"context"
+	"encoding/binary"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	. "gopkg.in/check.v1"
+)
+
+type DedupSuite struct{}
+
+var _ = Suite(&DedupSuite{})
+
+func (s *DedupSuite) TestExactKeySet(c *C) {
+	set := NewExactKeySet()
+	c.Check(set.Add([]byte("1.2.3.4")), Equals, true)
+	c.Check(set.Add([]byte("5.6.7.8")), Equals, true)
+	c.Check(set.Add([]byte("1.2.3.4")), Equals, false)
+}
+
+func (s *DedupSuite) TestBloomFilter(c *C) {
+	const n = 10000
+	b := NewBloomFilter(n, 0.01)
+	key := make([]byte, 8)
+	falsePositives := 0
+	for i := uint64(0); i < n; i++ {
+		binary.BigEndian.PutUint64(key, i)
+		if !b.Add(key) {
+			falsePositives++
+		}
+	}
+	c.Check(falsePositives < n/50, Equals, true)
+	for i := uint64(0); i < n; i++ {
+		binary.BigEndian.PutUint64(key, i)
+		c.Assert(b.Add(key), Equals, false)
+	}
+}
+
+func (s *DedupSuite) TestBloomFilterConcurrent(c *C) {
+	const n, goroutines = 1000, 8
+	b := NewBloomFilter(n, 0.01)
+	var added [n]atomic.Int32
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key := make([]byte, 8)
+			for i := uint64(0); i < n; i++ {
+				binary.BigEndian.PutUint64(key, i)
+				if b.Add(key) {
+					added[i].Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for i := range added {
+		c.Assert(added[i].Load() <= 1, Equals, true, Commentf("key %d", i))
+	}
+}
+
+func (s *DedupSuite) TestDedupWorker(c *C) {
+	values := []interface{}{1, 2, 1, 3, 2, 2, 4}
+	key := func(v interface{}) []byte {
+		return []byte(strconv.Itoa(v.(int)))
+	}
+	w := NewDedupWorker(new(testWorker), key, NewExactKeySet())
+	out := new(sliceEncoder)
+	err := ProcessConfig(context.Background(), &sliceDecoder{values: values}, out, w, 1, &Config{Ordered: true})
+	c.Assert(err, IsNil)
+	c.Check(out.values, DeepEquals, []interface{}{1, 2, 3, 4})
+	c.Check(w.Duplicates(), Equals, uint(3))
+	c.Check(w.Total(), Equals, uint(3))
+}
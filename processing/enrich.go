@@ -0,0 +1,369 @@
+package processing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zmap/ztools/zlog"
+)
+
+// DefaultReloadInterval is how often an Enricher checks its files for
+// changes if EnricherConfig.ReloadInterval is zero.
+const DefaultReloadInterval = 30 * time.Second
+
+type prefixNode struct {
+	children [2]*prefixNode
+	value    map[string]string
+}
+
+// PrefixTable maps IPv4 and IPv6 prefixes to sets of named fields, such as
+// an ASN and a country, and looks up the longest prefix that contains an
+// address. IPv4 addresses and IPv4-mapped IPv6 addresses are equivalent.
+// A PrefixTable is safe for concurrent lookups once it has been filled.
+type PrefixTable struct {
+	root prefixNode
+	size int
+}
+
+func NewPrefixTable() *PrefixTable {
+	return new(PrefixTable)
+}
+
+// prefixKey returns the 128-bit form of prefix and its length in that form,
+// in which IPv4 prefixes are IPv4-mapped.
+func prefixKey(prefix netip.Prefix) ([16]byte, int) {
+	bits := prefix.Bits()
+	if prefix.Addr().Is4() {
+		bits += 96
+	}
+	return prefix.Addr().As16(), bits
+}
+
+func bit(key [16]byte, i int) int {
+	return int(key[i/8]>>(7-i%8)) & 1
+}
+
+// Insert maps prefix to fields, replacing any fields it already had.
+func (t *PrefixTable) Insert(prefix netip.Prefix, fields map[string]string) {
+	key, bits := prefixKey(prefix.Masked())
+	n := &t.root
+	for i := 0; i < bits; i++ {
+		b := bit(key, i)
+		if n.children[b] == nil {
+			n.children[b] = new(prefixNode)
+		}
+		n = n.children[b]
+	}
+	if n.value == nil {
+		t.size++
+	}
+	n.value = fields
+}
+
+// Lookup returns the fields of the longest prefix containing addr.
+func (t *PrefixTable) Lookup(addr netip.Addr) (map[string]string, bool) {
+	if !addr.IsValid() {
+		return nil, false
+	}
+	key := addr.As16()
+	var match map[string]string
+	n := &t.root
+	for i := 0; n != nil; i++ {
+		if n.value != nil {
+			match = n.value
+		}
+		if i == 128 {
+			break
+		}
+		n = n.children[bit(key, i)]
+	}
+	return match, match != nil
+}
+
+// Len returns the number of prefixes in the table.
+func (t *PrefixTable) Len() int {
+	return t.size
+}
+
+// ParsePrefix parses a prefix in CIDR notation, or a single address.
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if strings.IndexByte(s, '/') >= 0 {
+		return netip.ParsePrefix(s)
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// LoadPrefixCSV adds the prefixes of CSV input to t. The first line is a
+// header; the first column holds the prefix, as accepted by ParsePrefix,
+// and the other columns the fields it is mapped to under their header
+// names, such as
+//
+//	network,asn,country
+//	192.0.2.0/24,64500,NL
+//	2001:db8::/32,64501,DE
+//
+// Empty fields are omitted. A row with an invalid prefix fails the load
+// with a *MalformedRecordError.
+func (t *PrefixTable) LoadPrefixCSV(r io.Reader) error {
+	d := NewCSVDecoder(r)
+	header, err := d.Header()
+	if err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	if len(header) == 0 {
+		return fmt.Errorf("processing: prefix table without columns")
+	}
+	for {
+		v, err := d.DecodeNext()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		row := v.(map[string]string)
+		prefix, err := ParsePrefix(row[header[0]])
+		if err != nil {
+			line, _ := d.r.FieldPos(0)
+			return &MalformedRecordError{Line: line, Err: err}
+		}
+		fields := make(map[string]string, len(header)-1)
+		for _, name := range header[1:] {
+			if row[name] != "" {
+				fields[name] = row[name]
+			}
+		}
+		t.Insert(prefix, fields)
+	}
+}
+
+// LoadPrefixFiles returns a PrefixTable holding the prefixes of the given
+// files, which may be compressed. Files named *.mmdb are read as MaxMind DB
+// databases by LoadMMDB, and others as CSV by LoadPrefixCSV. Later files
+// take precedence for prefixes that appear in several.
+func LoadPrefixFiles(names ...string) (*PrefixTable, error) {
+	t := NewPrefixTable()
+	for _, name := range names {
+		f, err := OpenInput(name)
+		if err != nil {
+			return nil, err
+		}
+		if isMMDB(name) {
+			err = t.LoadMMDB(f)
+		} else {
+			err = t.LoadPrefixCSV(f)
+		}
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("processing: loading %s: %w", name, err)
+		}
+	}
+	return t, nil
+}
+
+// EnricherConfig configures an Enricher.
+type EnricherConfig struct {
+	// Files are the CSV files or MaxMind DB databases of the prefix table,
+	// as read by LoadPrefixFiles.
+	Files []string
+	// IPField is the field path of the address to look up, such as "ip" or
+	// "saddr".
+	IPField string
+	// Field is the field that the fields of the matching prefix are stored
+	// under, as an object. If it is empty they are added to the record
+	// itself.
+	Field string
+	// ReloadInterval is how often Watch checks Files for changes, and
+	// defaults to DefaultReloadInterval.
+	ReloadInterval time.Duration
+	// Logger, if set, receives a line for every reload and every failed
+	// one.
+	Logger *zlog.Logger
+}
+
+// Enricher annotates records with the fields of the prefix their address
+// falls in, such as its ASN and country. Its table can be reloaded while it
+// is in use, so that long runs pick up updated files:
+//
+//	e, err := NewEnricher(&EnricherConfig{Files: []string{"asn.csv"}, IPField: "ip", Field: "asn"})
+//	go e.Watch(ctx)
+//	err = ProcessContext(ctx, in, out, NewEnrichWorker(w, e), workers)
+type Enricher struct {
+	config EnricherConfig
+	ip     Extractor
+	table  atomic.Pointer[PrefixTable]
+
+	mu      sync.Mutex
+	modTime time.Time
+}
+
+// NewEnricher loads the files of config and returns an Enricher for them.
+func NewEnricher(config *EnricherConfig) (*Enricher, error) {
+	e := &Enricher{
+		config: *config,
+		ip:     FieldPath(config.IPField),
+	}
+	if err := e.reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// latestModTime returns the most recent modification time of the files.
+func (e *Enricher) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range e.config.Files {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// Reload loads the files again and replaces the table. If loading fails, it
+// returns the error and keeps the current table.
+func (e *Enricher) Reload() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.reload()
+}
+
+func (e *Enricher) reload() error {
+	modTime, err := e.latestModTime()
+	if err != nil {
+		return err
+	}
+	t, err := LoadPrefixFiles(e.config.Files...)
+	if err != nil {
+		return err
+	}
+	e.modTime = modTime
+	e.table.Store(t)
+	return nil
+}
+
+// Watch reloads the table whenever one of the files has changed, until ctx
+// is done. A failed reload is retried once the files change again.
+func (e *Enricher) Watch(ctx context.Context) {
+	interval := e.config.ReloadInterval
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		e.reloadIfChanged()
+	}
+}
+
+func (e *Enricher) reloadIfChanged() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	modTime, err := e.latestModTime()
+	if err != nil || modTime.Equal(e.modTime) {
+		return
+	}
+	if err := e.reload(); err != nil {
+		// Remember the failed version so that it is not retried on every
+		// tick.
+		e.modTime = modTime
+		if e.config.Logger != nil {
+			e.config.Logger.Errorf("reloading prefix table: %v", err)
+		}
+		return
+	}
+	if e.config.Logger != nil {
+		e.config.Logger.Infof("reloaded prefix table: %d prefixes", e.Table().Len())
+	}
+}
+
+// Table returns the current table.
+func (e *Enricher) Table() *PrefixTable {
+	return e.table.Load()
+}
+
+// Lookup returns the fields of the prefix containing the address of record
+// v.
+func (e *Enricher) Lookup(v interface{}) (map[string]string, bool) {
+	for _, value := range e.ip(v) {
+		s, ok := value.(string)
+		if !ok {
+			continue
+		}
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			continue
+		}
+		return e.Table().Lookup(addr)
+	}
+	return nil, false
+}
+
+// Annotate adds the fields of the prefix containing the address of record
+// v to v, and returns it. Only records that are a map[string]interface{},
+// such as those of a JSONLinesDecoder, can be annotated; others, and
+// records without a known address, are returned unchanged.
+func (e *Enricher) Annotate(v interface{}) interface{} {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return v
+	}
+	fields, ok := e.Lookup(m)
+	if !ok {
+		return v
+	}
+	target := m
+	if e.config.Field != "" {
+		target = make(map[string]interface{}, len(fields))
+		m[e.config.Field] = target
+	}
+	for name, value := range fields {
+		target[name] = value
+	}
+	return m
+}
+
+// EnrichWorker wraps a Worker so that the results of its handlers are
+// annotated by an Enricher. Failed and skipped records are passed through
+// unchanged.
+type EnrichWorker struct {
+	wrappedWorker
+}
+
+func NewEnrichWorker(w Worker, e *Enricher) *EnrichWorker {
+	return &EnrichWorker{
+		wrappedWorker: wrapWorker(w, mapResults(e.Annotate)),
+	}
+}
+
+// Enrich starts a flow stage that annotates each record with e.
+func Enrich[T any](s *Stream[T], opts StageOptions, e *Enricher) *Stream[T] {
+	return Map(s, opts, func(v T) (T, error) {
+		e.Annotate(v)
+		return v, nil
+	})
+}
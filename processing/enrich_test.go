@@ -0,0 +1,100 @@
+package processing
+
+import (
+	"bytes"
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type EnrichSuite struct{}
+
+var _ = Suite(&EnrichSuite{})
+
+const asnTable = `network,asn,country
+10.0.0.0/8,64500,NL
+10.1.0.0/16,64501,
+10.1.2.3,64502,DE
+2001:db8::/32,64510,FR
+::ffff:192.0.2.0/120,64520,US
+`
+
+func (s *EnrichSuite) TestPrefixTable(c *C) {
+	t := NewPrefixTable()
+	c.Assert(t.LoadPrefixCSV(strings.NewReader(asnTable)), IsNil)
+	c.Check(t.Len(), Equals, 5)
+	for addr, want := range map[string]map[string]string{
+		"10.9.9.9":        {"asn": "64500", "country": "NL"},
+		"10.1.9.9":        {"asn": "64501"},
+		"10.1.2.3":        {"asn": "64502", "country": "DE"},
+		"::ffff:10.1.2.3": {"asn": "64502", "country": "DE"},
+		"192.0.2.77":      {"asn": "64520", "country": "US"},
+		"2001:db8:1::1":   {"asn": "64510", "country": "FR"},
+		"11.0.0.1":        nil,
+		"2001:db9::1":     nil,
+		"::a01:203":       nil,
+	} {
+		fields, ok := t.Lookup(netip.MustParseAddr(addr))
+		c.Check(ok, Equals, want != nil, Commentf("%s", addr))
+		if want != nil {
+			c.Check(fields, DeepEquals, want, Commentf("%s", addr))
+		}
+	}
+	c.Check(t.LoadPrefixCSV(strings.NewReader("network,asn\n10.0.0.0/33,1\n")), NotNil)
+}
+
+func (s *EnrichSuite) TestProcessAndReload(c *C) {
+	dir := c.MkDir()
+	name := filepath.Join(dir, "asn.csv")
+	c.Assert(os.WriteFile(name, []byte(asnTable), 0644), IsNil)
+	e, err := NewEnricher(&EnricherConfig{Files: []string{name}, IPField: "ip", Field: "asn", ReloadInterval: 10 * time.Millisecond})
+	c.Assert(err, IsNil)
+
+	in := "{\"ip\":\"10.1.2.3\"}\n{\"ip\":\"11.0.0.1\"}\n{\"port\":80}\n"
+	var out bytes.Buffer
+	err = ProcessConfig(context.Background(), NewJSONLinesDecoder(strings.NewReader(in)),
+		NewJSONLinesEncoder(&out), NewEnrichWorker(new(testWorker), e), 2, &Config{Ordered: true})
+	c.Assert(err, IsNil)
+	c.Check(out.String(), Equals, "{\"asn\":{\"asn\":\"64502\",\"country\":\"DE\"},\"ip\":\"10.1.2.3\"}\n{\"ip\":\"11.0.0.1\"}\n{\"port\":80}\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Watch(ctx)
+	c.Assert(os.WriteFile(name, []byte("network,asn\n11.0.0.0/8,64600\n"), 0644), IsNil)
+	later := time.Now().Add(time.Second)
+	c.Assert(os.Chtimes(name, later, later), IsNil)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if fields, ok := e.Table().Lookup(netip.MustParseAddr("11.0.0.1")); ok {
+			c.Check(fields, DeepEquals, map[string]string{"asn": "64600"})
+			break
+		}
+		c.Assert(time.Now().Before(deadline), Equals, true)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// A broken file keeps the current table.
+	c.Assert(os.WriteFile(name, []byte("network,asn\nnot a prefix,1\n"), 0644), IsNil)
+	c.Check(e.Reload(), NotNil)
+	_, ok := e.Table().Lookup(netip.MustParseAddr("11.0.0.1"))
+	c.Check(ok, Equals, true)
+}
+
+func (s *EnrichSuite) TestAnnotateInPlace(c *C) {
+	t := NewPrefixTable()
+	c.Assert(t.LoadPrefixCSV(strings.NewReader(asnTable)), IsNil)
+	e := &Enricher{ip: FieldPath("host.ip")}
+	e.table.Store(t)
+	record := map[string]interface{}{"host": map[string]interface{}{"ip": "2001:db8::1"}}
+	c.Check(e.Annotate(record), DeepEquals, map[string]interface{}{
+		"host":    map[string]interface{}{"ip": "2001:db8::1"},
+		"asn":     "64510",
+		"country": "FR",
+	})
+	c.Check(e.Annotate("1.2.3.4"), Equals, "1.2.3.4")
+}
@@ -0,0 +1,86 @@
+package processing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrHandlerTimeout = errors.New("processing: handler timed out")
+	// ErrSkipRecord is returned by a TypedHandler to drop the record it is
+	// handling without writing a result.
+	ErrSkipRecord = errors.New("processing: skip record")
+)
+
+// Skip is returned by a Handler to drop the record it is handling without
+// writing a result.
+var Skip interface{} = skipRecord{}
+
+type skipRecord struct{}
+
+// MalformedRecordError reports an input record that could not be decoded.
+// The decoder that returned it remains usable and continues with the next
+// record.
+type MalformedRecordError struct {
+	Line int
+	Err  error
+}
+
+func (e *MalformedRecordError) Error() string {
+	return fmt.Sprintf("processing: malformed record on line %d: %v", e.Line, e.Err)
+}
+
+func (e *MalformedRecordError) Unwrap() error {
+	return e.Err
+}
+
+// PanicError is the cause of a RecordError for a handler that panicked.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("processing: handler panicked: %v", e.Value)
+}
+
+// RecordError is emitted in place of a handler result when the handler
+// fails on a record. It carries the offending input.
+type RecordError struct {
+	Input interface{}
+	Err   error
+}
+
+// Fail returns a handler result that marks the record being handled as
+// failed because of err. Process fills in the input and, if the run has a
+// dead-letter encoder, writes it there instead of to the output.
+func Fail(err error) interface{} {
+	return &RecordError{Err: err}
+}
+
+func (e *RecordError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+type encodedRecordError struct {
+	Input interface{} `json:"input"`
+	Error string      `json:"error"`
+	Stack string      `json:"stack,omitempty"`
+}
+
+func (e *RecordError) MarshalJSON() ([]byte, error) {
+	enc := encodedRecordError{
+		Input: e.Input,
+		Error: e.Err.Error(),
+	}
+	var panicErr *PanicError
+	if errors.As(e.Err, &panicErr) {
+		enc.Stack = string(panicErr.Stack)
+	}
+	return json.Marshal(enc)
+}
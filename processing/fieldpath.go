@@ -0,0 +1,87 @@
+package processing
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Extractor returns the values a record holds at some position, such as a
+// field path. It returns no values if the record has none there.
+type Extractor func(v interface{}) []interface{}
+
+// normalize returns v as generic JSON: maps, slices, strings, float64s,
+// bools and nil. Values of other types, such as a *ztls.ServerHandshake,
+// are converted through their JSON encoding so that paths follow their JSON
+// field names.
+func normalize(v interface{}) (interface{}, error) {
+	switch v.(type) {
+	case nil, map[string]interface{}, []interface{}, string, float64, bool:
+		return v, nil
+	case map[string]string:
+		m := make(map[string]interface{})
+		for key, value := range v.(map[string]string) {
+			m[key] = value
+		}
+		return m, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var n interface{}
+	if err := json.Unmarshal(b, &n); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// lookup returns the values at path inside the generic JSON value v.
+// Arrays along the path are traversed element by element, so a path can
+// yield several values, such as one per certificate.
+func lookup(v interface{}, path []string) []interface{} {
+	if len(path) == 0 {
+		if a, ok := v.([]interface{}); ok {
+			return a
+		}
+		if v == nil {
+			return nil
+		}
+		return []interface{}{v}
+	}
+	switch n := v.(type) {
+	case map[string]interface{}:
+		child, ok := n[path[0]]
+		if !ok {
+			return nil
+		}
+		return lookup(child, path[1:])
+	case []interface{}:
+		var values []interface{}
+		for _, elem := range n {
+			values = append(values, lookup(elem, path)...)
+		}
+		return values
+	}
+	return nil
+}
+
+func splitPath(path string) []string {
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
+
+// FieldPath returns an Extractor for a dotted path of JSON field names, such
+// as "server_hello.cipher_suite". Records that cannot be encoded as JSON
+// yield no values.
+func FieldPath(path string) Extractor {
+	parts := splitPath(path)
+	return func(v interface{}) []interface{} {
+		n, err := normalize(v)
+		if err != nil {
+			return nil
+		}
+		return lookup(n, parts)
+	}
+}
@@ -0,0 +1,588 @@
+package processing
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// FilterSyntaxError reports an expression that ParseFilter could not parse.
+type FilterSyntaxError struct {
+	Offset int
+	Msg    string
+}
+
+func (e *FilterSyntaxError) Error() string {
+	return fmt.Sprintf("processing: filter syntax error at offset %d: %s", e.Offset, e.Msg)
+}
+
+// FilterExpr is a compiled filter expression that selects records by the
+// values of their fields, such as
+//
+//	server_hello.version == 0x0300 && server_certificates.valid == false
+//
+// Field paths are dotted JSON field names, as in FieldPath, so that they
+// match the JSON tags of ztls.ServerHandshake and the output of other
+// tools. Expressions are built from
+//
+//	comparisons    path == value, !=, <, <=, >, >=
+//	membership     path in [value, ...]
+//	regexps        path =~ "pattern", path !~ "pattern"
+//	boolean ops    &&, ||, !, also spelled and, or, not, and parentheses
+//	truth tests    a path on its own
+//
+// Values are numbers, including hex numbers such as 0x0303, strings in
+// double quotes or backquotes, true, false, null, or other paths.
+//
+// A path that runs through arrays, such as certificates.issuer, can have
+// several values, and a comparison holds if it holds for any of them. A
+// missing field has no values, so comparisons with it are false, except
+// that it equals null. != and !~ are the negations of == and =~. A path on
+// its own is true if any of its values is other than false, 0 or "".
+type FilterExpr struct {
+	expr string
+	root filterNode
+}
+
+// ParseFilter compiles a filter expression.
+func ParseFilter(expr string) (*FilterExpr, error) {
+	p := &filterParser{lex: filterLexer{src: expr}}
+	p.next()
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %s", p.tok)
+	}
+	return &FilterExpr{expr: expr, root: root}, nil
+}
+
+// MustParseFilter is like ParseFilter but panics if expr cannot be parsed.
+func MustParseFilter(expr string) *FilterExpr {
+	f, err := ParseFilter(expr)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+func (f *FilterExpr) String() string {
+	return f.expr
+}
+
+// Match reports whether record v satisfies the filter. Records that cannot
+// be encoded as JSON do not match.
+func (f *FilterExpr) Match(v interface{}) bool {
+	n, err := normalize(v)
+	if err != nil {
+		return false
+	}
+	return f.root.match(n)
+}
+
+// FilterWorker wraps a Worker so that records that do not match a FilterExpr
+// are skipped before they reach the wrapped Worker's handlers. Dropped
+// records are counted by Rejected and included in Total.
+type FilterWorker struct {
+	wrappedWorker
+	filter   *FilterExpr
+	rejected atomic.Uint64
+}
+
+func NewFilterWorker(w Worker, f *FilterExpr) *FilterWorker {
+	fw := &FilterWorker{filter: f}
+	fw.wrappedWorker = wrapWorker(w, func(handler Handler) Handler {
+		return func(v interface{}) interface{} {
+			if !fw.filter.Match(v) {
+				fw.rejected.Add(1)
+				return Skip
+			}
+			return handler(v)
+		}
+	})
+	return fw
+}
+
+// Rejected returns the number of records dropped by the filter.
+func (fw *FilterWorker) Rejected() uint {
+	return uint(fw.rejected.Load())
+}
+
+func (fw *FilterWorker) Total() uint {
+	return fw.Worker.Total() + fw.Rejected()
+}
+
+// Where starts a flow stage that drops the records that do not match f.
+func Where[T any](s *Stream[T], opts StageOptions, f *FilterExpr) *Stream[T] {
+	return Filter(s, opts, func(v T) (bool, error) {
+		return f.Match(v), nil
+	})
+}
+
+type filterNode interface {
+	match(v interface{}) bool
+}
+
+// operand yields the values one side of a comparison takes for a record.
+type operand interface {
+	values(v interface{}) []interface{}
+}
+
+type pathOperand []string
+
+func (p pathOperand) values(v interface{}) []interface{} {
+	return lookup(v, p)
+}
+
+type literalOperand struct {
+	value interface{}
+}
+
+func (l literalOperand) values(interface{}) []interface{} {
+	if l.value == nil {
+		return nil
+	}
+	return []interface{}{l.value}
+}
+
+type orNode struct{ left, right filterNode }
+
+func (n orNode) match(v interface{}) bool {
+	return n.left.match(v) || n.right.match(v)
+}
+
+type andNode struct{ left, right filterNode }
+
+func (n andNode) match(v interface{}) bool {
+	return n.left.match(v) && n.right.match(v)
+}
+
+type notNode struct{ node filterNode }
+
+func (n notNode) match(v interface{}) bool {
+	return !n.node.match(v)
+}
+
+type truthNode struct{ operand operand }
+
+func (n truthNode) match(v interface{}) bool {
+	for _, x := range n.operand.values(v) {
+		switch x {
+		case false, 0.0, "":
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// nullNode matches records for which operand has no values.
+type nullNode struct{ operand operand }
+
+func (n nullNode) match(v interface{}) bool {
+	return len(n.operand.values(v)) == 0
+}
+
+type compareNode struct {
+	left, right operand
+	op          string
+}
+
+func (n compareNode) match(v interface{}) bool {
+	rights := n.right.values(v)
+	for _, l := range n.left.values(v) {
+		for _, r := range rights {
+			if compare(l, n.op, r) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compare applies op to two generic JSON values. Only numbers and strings
+// are ordered.
+func compare(l interface{}, op string, r interface{}) bool {
+	if op == "==" {
+		switch l.(type) {
+		case float64, string, bool:
+			return l == r
+		}
+		return false
+	}
+	switch l := l.(type) {
+	case float64:
+		if r, ok := r.(float64); ok {
+			switch op {
+			case "<":
+				return l < r
+			case "<=":
+				return l <= r
+			case ">":
+				return l > r
+			case ">=":
+				return l >= r
+			}
+		}
+	case string:
+		if r, ok := r.(string); ok {
+			switch op {
+			case "<":
+				return l < r
+			case "<=":
+				return l <= r
+			case ">":
+				return l > r
+			case ">=":
+				return l >= r
+			}
+		}
+	}
+	return false
+}
+
+type inNode struct {
+	operand operand
+	set     []interface{}
+}
+
+func (n inNode) match(v interface{}) bool {
+	for _, x := range n.operand.values(v) {
+		for _, y := range n.set {
+			if compare(x, "==", y) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type regexpNode struct {
+	operand operand
+	re      *regexp.Regexp
+}
+
+func (n regexpNode) match(v interface{}) bool {
+	for _, x := range n.operand.values(v) {
+		if s, ok := x.(string); ok && n.re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokPath
+	tokNumber
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+type filterLexer struct {
+	src string
+	pos int
+}
+
+var filterOps = []string{"==", "!=", "<=", ">=", "=~", "!~", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ","}
+
+func isPathChar(c byte, first bool) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' ||
+		!first && (c == '.' || '0' <= c && c <= '9')
+}
+
+func (l *filterLexer) next() (token, error) {
+	for l.pos < len(l.src) && strings.IndexByte(" \t\r\n", l.src[l.pos]) >= 0 {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos == len(l.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+	c := l.src[l.pos]
+	switch {
+	case isPathChar(c, true):
+		for l.pos < len(l.src) && isPathChar(l.src[l.pos], false) {
+			l.pos++
+		}
+		return token{kind: tokPath, text: l.src[start:l.pos], pos: start}, nil
+	case c == '-' || '0' <= c && c <= '9':
+		l.pos++
+		for l.pos < len(l.src) && (isPathChar(l.src[l.pos], false) || l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		return token{kind: tokNumber, text: l.src[start:l.pos], pos: start}, nil
+	case c == '"' || c == '`':
+		l.pos++
+		for l.pos < len(l.src) && l.src[l.pos] != c {
+			if c == '"' && l.src[l.pos] == '\\' {
+				l.pos++
+			}
+			l.pos++
+		}
+		if l.pos >= len(l.src) {
+			return token{}, &FilterSyntaxError{Offset: start, Msg: "unterminated string"}
+		}
+		l.pos++
+		s, err := strconv.Unquote(l.src[start:l.pos])
+		if err != nil {
+			return token{}, &FilterSyntaxError{Offset: start, Msg: "invalid string " + l.src[start:l.pos]}
+		}
+		return token{kind: tokString, text: s, pos: start}, nil
+	}
+	for _, op := range filterOps {
+		if strings.HasPrefix(l.src[l.pos:], op) {
+			l.pos += len(op)
+			return token{kind: tokOp, text: op, pos: start}, nil
+		}
+	}
+	return token{}, &FilterSyntaxError{Offset: start, Msg: fmt.Sprintf("unexpected character %q", c)}
+}
+
+type filterParser struct {
+	lex filterLexer
+	tok token
+	err error
+}
+
+func (p *filterParser) next() {
+	if p.err != nil {
+		return
+	}
+	p.tok, p.err = p.lex.next()
+	if p.err != nil {
+		p.tok = token{kind: tokEOF, pos: p.lex.pos}
+	}
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	if p.err != nil {
+		return p.err
+	}
+	return &FilterSyntaxError{Offset: p.tok.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// is reports whether the current token is the operator or keyword op, or
+// one of its spellings.
+func (p *filterParser) is(ops ...string) bool {
+	if p.tok.kind != tokOp && p.tok.kind != tokPath {
+		return false
+	}
+	for _, op := range ops {
+		if p.tok.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.is("||", "or") {
+		p.next()
+		var right filterNode
+		if right, err = p.parseAnd(); err == nil {
+			left = orNode{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseNot()
+	for err == nil && p.is("&&", "and") {
+		p.next()
+		var right filterNode
+		if right, err = p.parseNot(); err == nil {
+			left = andNode{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *filterParser) parseNot() (filterNode, error) {
+	if p.is("!", "not") {
+		p.next()
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{node}, nil
+	}
+	if p.is("(") {
+		p.next()
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.is(")") {
+			return nil, p.errorf("expected \")\", found %s", p.tok)
+		}
+		p.next()
+		return node, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (filterNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case p.is("==", "!=", "<", "<=", ">", ">="):
+		op := p.tok.text
+		p.next()
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		var node filterNode
+		switch {
+		case isNull(left) || isNull(right):
+			if op != "==" && op != "!=" {
+				return nil, p.errorf("null can only be compared with == or !=")
+			}
+			if isNull(left) {
+				left = right
+			}
+			node = nullNode{left}
+		case op == "!=":
+			node = compareNode{left: left, right: right, op: "=="}
+		default:
+			node = compareNode{left: left, right: right, op: op}
+		}
+		if op == "!=" {
+			return notNode{node}, nil
+		}
+		return node, nil
+	case p.is("=~", "!~"):
+		op := p.tok.text
+		p.next()
+		if p.tok.kind != tokString {
+			return nil, p.errorf("expected regexp string, found %s", p.tok)
+		}
+		re, err := regexp.Compile(p.tok.text)
+		if err != nil {
+			return nil, p.errorf("invalid regexp: %v", err)
+		}
+		p.next()
+		var node filterNode = regexpNode{left, re}
+		if op == "!~" {
+			node = notNode{node}
+		}
+		return node, nil
+	case p.is("in"):
+		p.next()
+		set, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		return inNode{left, set}, nil
+	}
+	return truthNode{left}, nil
+}
+
+func isNull(o operand) bool {
+	l, ok := o.(literalOperand)
+	return ok && l.value == nil
+}
+
+func (p *filterParser) parseList() ([]interface{}, error) {
+	if !p.is("[") {
+		return nil, p.errorf("expected \"[\", found %s", p.tok)
+	}
+	p.next()
+	var set []interface{}
+	for !p.is("]") {
+		if len(set) > 0 {
+			if !p.is(",") {
+				return nil, p.errorf("expected \",\" or \"]\", found %s", p.tok)
+			}
+			p.next()
+		}
+		o, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		l, ok := o.(literalOperand)
+		if !ok {
+			return nil, p.errorf("list elements must be literals")
+		}
+		set = append(set, l.value)
+	}
+	p.next()
+	return set, nil
+}
+
+func (p *filterParser) parseOperand() (operand, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokString:
+		p.next()
+		return literalOperand{tok.text}, nil
+	case tokNumber:
+		n, err := parseFilterNumber(tok.text)
+		if err != nil {
+			return nil, p.errorf("invalid number %s", tok)
+		}
+		p.next()
+		return literalOperand{n}, nil
+	case tokPath:
+		switch tok.text {
+		case "true":
+			p.next()
+			return literalOperand{true}, nil
+		case "false":
+			p.next()
+			return literalOperand{false}, nil
+		case "null":
+			p.next()
+			return literalOperand{nil}, nil
+		case "and", "or", "not", "in":
+			return nil, p.errorf("unexpected %s", tok)
+		}
+		for _, part := range strings.Split(tok.text, ".") {
+			if part == "" {
+				return nil, p.errorf("invalid field path %s", tok)
+			}
+		}
+		p.next()
+		return pathOperand(splitPath(tok.text)), nil
+	}
+	return nil, p.errorf("expected field path or value, found %s", tok)
+}
+
+func parseFilterNumber(s string) (float64, error) {
+	neg := strings.HasPrefix(s, "-")
+	digits := strings.TrimPrefix(s, "-")
+	if strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X") {
+		n, err := strconv.ParseUint(digits[2:], 16, 64)
+		if neg {
+			return -float64(n), err
+		}
+		return float64(n), err
+	}
+	return strconv.ParseFloat(s, 64)
+}
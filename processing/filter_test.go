@@ -0,0 +1,87 @@
+package processing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type FilterSuite struct{}
+
+var _ = Suite(&FilterSuite{})
+
+const filterRecord = `{
+	"ip": "10.0.0.1",
+	"server_hello": {"version": 768, "cipher_suite": 49199, "heartbeat": true},
+	"server_certificates": {"valid": false, "validation_error": "x509: certificate has expired", "issuer": "Example CA", "alt_names": ["a.example.com", "b.example.com"]},
+	"tags": []
+}`
+
+func (s *FilterSuite) TestMatch(c *C) {
+	var record interface{}
+	c.Assert(json.Unmarshal([]byte(filterRecord), &record), IsNil)
+	for expr, want := range map[string]bool{
+		`server_hello.version == 0x0300 && server_certificates.valid == false`: true,
+		`server_hello.version == 0x0303 || server_certificates.valid`:          false,
+		`server_hello.version >= 768 and server_hello.version < 769`:           true,
+		`server_hello.cipher_suite in [0xc02f, 0xc030]`:                        true,
+		`server_hello.cipher_suite in [1, "49199"]`:                            false,
+		`server_certificates.issuer =~ "^Example"`:                             true,
+		`server_certificates.issuer !~ "^Example"`:                             false,
+		`server_certificates.validation_error =~ ` + "`expired$`":              true,
+		`server_certificates.alt_names == "b.example.com"`:                     true,
+		`server_certificates.alt_names != "b.example.com"`:                     false,
+		`server_hello.heartbeat && !server_certificates.valid`:                 true,
+		`not (server_hello.heartbeat or ip == "10.0.0.1")`:                     false,
+		`server_key_exchange == null`:                                          true,
+		`server_hello != null`:                                                 true,
+		`server_key_exchange.key == "x"`:                                       false,
+		`server_key_exchange.key != "x"`:                                       true,
+		`tags`:                                                                 false,
+		`ip > "10" && ip < "11"`:                                               true,
+		`server_hello.version == server_hello.version`:                         true,
+		`server_hello.version > -1.5e3`:                                        true,
+	} {
+		f, err := ParseFilter(expr)
+		c.Assert(err, IsNil, Commentf("%s", expr))
+		c.Check(f.Match(record), Equals, want, Commentf("%s", expr))
+	}
+}
+
+func (s *FilterSuite) TestSyntaxErrors(c *C) {
+	for expr, offset := range map[string]int{
+		``:                     0,
+		`a ==`:                 4,
+		`a == "b`:              5,
+		`(a == 1`:              7,
+		`a in 1`:               5,
+		`a in [b]`:             7,
+		`a =~ "("`:             5,
+		`a < null`:             8,
+		`a == 1 b`:             7,
+		`a # b`:                2,
+		`a.b. == 1`:            0,
+		`server_hello.version`: -1,
+	} {
+		_, err := ParseFilter(expr)
+		if offset < 0 {
+			c.Check(err, IsNil)
+			continue
+		}
+		serr, ok := err.(*FilterSyntaxError)
+		c.Assert(ok, Equals, true, Commentf("%q: %v", expr, err))
+		c.Check(serr.Offset, Equals, offset, Commentf("%q: %v", expr, err))
+	}
+}
+
+func (s *FilterSuite) TestFilterWorker(c *C) {
+	in := "{\"ip\":\"1.2.3.4\",\"port\":443}\n{\"ip\":\"5.6.7.8\",\"port\":80}\n{\"ip\":\"9.9.9.9\",\"port\":8443}\n"
+	var out bytes.Buffer
+	w := NewFilterWorker(new(testWorker), MustParseFilter("port in [443, 8443]"))
+	c.Assert(ProcessConfig(context.Background(), NewJSONLinesDecoder(strings.NewReader(in)), NewJSONLinesEncoder(&out), w, 2, &Config{Ordered: true}), IsNil)
+	c.Check(out.String(), Equals, "{\"ip\":\"1.2.3.4\",\"port\":443}\n{\"ip\":\"9.9.9.9\",\"port\":8443}\n")
+	c.Check(w.Rejected(), Equals, uint(1))
+}
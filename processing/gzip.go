@@ -0,0 +1,170 @@
+package processing
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// DefaultGzipBlockSize is the amount of uncompressed data a
+// ParallelGzipWriter compresses as one unit.
+const DefaultGzipBlockSize = 1 << 20
+
+// ParallelGzipWriter compresses blocks of its input concurrently and writes
+// each block as a separate gzip member, in order. Any gzip reader that
+// supports multistream input, including compress/gzip and gunzip, reads the
+// result as a single stream.
+type ParallelGzipWriter struct {
+	w         io.Writer
+	level     int
+	blockSize int
+
+	buf     []byte
+	pending chan *gzipBlock
+	last    *gzipBlock
+	wrote   bool
+	closed  bool
+	done    chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+type gzipBlock struct {
+	data       []byte
+	compressed bytes.Buffer
+	err        error
+	ready      chan struct{}
+	written    chan struct{}
+}
+
+// NewParallelGzipWriter returns a writer that compresses with the given
+// gzip level using up to concurrency goroutines. A concurrency of zero uses
+// GOMAXPROCS.
+func NewParallelGzipWriter(w io.Writer, level int, concurrency int) *ParallelGzipWriter {
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	z := &ParallelGzipWriter{
+		w:         w,
+		level:     level,
+		blockSize: DefaultGzipBlockSize,
+		pending:   make(chan *gzipBlock, concurrency),
+		done:      make(chan struct{}),
+	}
+	go z.writeBlocks()
+	return z
+}
+
+func (z *ParallelGzipWriter) setErr(err error) {
+	z.mu.Lock()
+	if z.err == nil {
+		z.err = err
+	}
+	z.mu.Unlock()
+}
+
+func (z *ParallelGzipWriter) getErr() error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.err
+}
+
+func (z *ParallelGzipWriter) writeBlocks() {
+	defer close(z.done)
+	for b := range z.pending {
+		<-b.ready
+		if z.getErr() == nil {
+			if b.err != nil {
+				z.setErr(b.err)
+			} else if _, err := z.w.Write(b.compressed.Bytes()); err != nil {
+				z.setErr(err)
+			}
+		}
+		close(b.written)
+	}
+}
+
+func (z *ParallelGzipWriter) submit() {
+	b := &gzipBlock{
+		data:    z.buf,
+		ready:   make(chan struct{}),
+		written: make(chan struct{}),
+	}
+	z.buf = nil
+	z.last = b
+	z.wrote = true
+	// The send blocks once concurrency blocks are in flight, which bounds
+	// both memory use and the number of compressing goroutines.
+	z.pending <- b
+	go func() {
+		defer close(b.ready)
+		gz, err := gzip.NewWriterLevel(&b.compressed, z.level)
+		if err != nil {
+			b.err = err
+			return
+		}
+		if _, err := gz.Write(b.data); err != nil {
+			b.err = err
+			return
+		}
+		b.err = gz.Close()
+	}()
+}
+
+func (z *ParallelGzipWriter) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, ErrWriterClosed
+	}
+	if err := z.getErr(); err != nil {
+		return 0, err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		if z.buf == nil {
+			z.buf = make([]byte, 0, z.blockSize)
+		}
+		room := z.blockSize - len(z.buf)
+		if room > len(p) {
+			room = len(p)
+		}
+		z.buf = append(z.buf, p[:room]...)
+		p = p[room:]
+		if len(z.buf) == z.blockSize {
+			z.submit()
+		}
+	}
+	return n, nil
+}
+
+// Flush compresses any buffered data and waits until every block has been
+// written to the underlying writer.
+func (z *ParallelGzipWriter) Flush() error {
+	if len(z.buf) > 0 {
+		z.submit()
+	}
+	if z.last != nil {
+		<-z.last.written
+	}
+	return z.getErr()
+}
+
+// Close flushes the writer. It does not close the underlying writer.
+// Closing it again has no effect.
+func (z *ParallelGzipWriter) Close() error {
+	if z.closed {
+		return z.getErr()
+	}
+	z.closed = true
+	if len(z.buf) > 0 || !z.wrote {
+		// Without any input this emits an empty member, so that the output
+		// is still valid gzip.
+		z.submit()
+	}
+	err := z.Flush()
+	close(z.pending)
+	<-z.done
+	return err
+}
@@ -1,6 +1,6 @@
 package processing
 
-import "io"
+import "context"
 
 type Decoder interface {
 	DecodeNext() (interface{}, error)
@@ -10,6 +10,12 @@ type Encoder interface {
 	Encode(v interface{}) error
 }
 
+// Worker makes the handlers of a run, one per worker goroutine, with ids
+// below the number of workers. A handler abandoned after Config.HandlerTimeout
+// holds its id until it returns, so ids can briefly exceed that. Records that
+// fail because their handler panicked or timed out are counted only if the
+// Worker also implements FailureRecorder, as Counters does. MakeHandler is
+// never called concurrently, but the handlers it returns run concurrently.
 type Worker interface {
 	MakeHandler(uint) Handler
 	Success() uint
@@ -20,42 +26,29 @@ type Worker interface {
 
 type Handler func(interface{}) interface{}
 
+// Process runs ProcessContext without cancellation, discarding its error.
 func Process(in Decoder, out Encoder, w Worker, workers uint) {
-	processQueue := make(chan interface{}, workers*4)
-	outputQueue := make(chan interface{}, workers*4)
-	workerDone := make(chan int, workers)
-	outputDone := make(chan int, 1)
-	// Start the output encoder
-	go func() {
-		for result := range outputQueue {
-			out.Encode(result)
-		}
-		outputDone <- 1
-	}()
-	// Start all the workers
-	for i := uint(0); i < workers; i++ {
-		handler := w.MakeHandler(i)
-		go func(handler Handler) {
-			for obj := range processQueue {
-				result := handler(obj)
-				outputQueue <- result
-			}
-			workerDone <- 1
-		}(handler)
-	}
-	// Read the input, send to workers
-	for {
-		obj, err := in.DecodeNext()
-		if err == io.EOF {
-			break
-		}
-		processQueue <- obj
-	}
-	close(processQueue)
-	for i := uint(0); i < workers; i++ {
-		<-workerDone
+	ProcessContext(context.Background(), in, out, w, workers)
+}
+
+// ProcessContext runs ProcessConfig with the default configuration.
+func ProcessContext(ctx context.Context, in Decoder, out Encoder, w Worker, workers uint) error {
+	return ProcessConfig(ctx, in, out, w, workers, nil)
+}
+
+// ProcessConfig decodes records from in, passes each one to a handler
+// created by w, and encodes the results to out. It returns when the input is
+// exhausted, when ctx is cancelled, or after the first decode or encode
+// error. All worker and output goroutines have exited by the time it returns,
+// but handler calls abandoned after Config.HandlerTimeout or cancellation may
+// still be running.
+func ProcessConfig(ctx context.Context, in Decoder, out Encoder, w Worker, workers uint, config *Config) error {
+	pl := &Pipeline[interface{}, interface{}]{
+		Decoder: in,
+		Encoder: out,
+		Worker:  untypedWorker{w},
+		Workers: workers,
+		Config:  config,
 	}
-	close(outputQueue)
-	<-outputDone
-	w.Done()
+	return pl.Run(ctx)
 }
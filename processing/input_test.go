@@ -0,0 +1,131 @@
+package processing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type ProcessSuite struct{}
+
+var _ = Suite(&ProcessSuite{})
+
+type sliceDecoder struct {
+	values []interface{}
+	err    error
+}
+
+func (d *sliceDecoder) DecodeNext() (interface{}, error) {
+	if len(d.values) == 0 {
+		if d.err != nil {
+			return nil, d.err
+		}
+		return nil, io.EOF
+	}
+	v := d.values[0]
+	d.values = d.values[1:]
+	return v, nil
+}
+
+type sliceEncoder struct {
+	mu     sync.Mutex
+	values []interface{}
+	err    error
+}
+
+func (e *sliceEncoder) Encode(v interface{}) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.err != nil {
+		return e.err
+	}
+	e.values = append(e.values, v)
+	return nil
+}
+
+type testWorker struct {
+	handler Handler
+	done    bool
+}
+
+func (w *testWorker) MakeHandler(uint) Handler {
+	if w.handler != nil {
+		return w.handler
+	}
+	return func(v interface{}) interface{} { return v }
+}
+
+func (w *testWorker) Success() uint { return 0 }
+func (w *testWorker) Failure() uint { return 0 }
+func (w *testWorker) Total() uint   { return 0 }
+func (w *testWorker) Done()         { w.done = true }
+
+func intRange(n int) []interface{} {
+	values := make([]interface{}, n)
+	for i := range values {
+		values[i] = i
+	}
+	return values
+}
+
+func (s *ProcessSuite) TestProcessAll(c *C) {
+	in := &sliceDecoder{values: intRange(100)}
+	out := new(sliceEncoder)
+	w := new(testWorker)
+	err := ProcessContext(context.Background(), in, out, w, 4)
+	c.Assert(err, IsNil)
+	c.Check(out.values, HasLen, 100)
+	c.Check(w.done, Equals, true)
+}
+
+func (s *ProcessSuite) TestDecodeError(c *C) {
+	decodeErr := errors.New("truncated input")
+	in := &sliceDecoder{values: intRange(10), err: decodeErr}
+	out := new(sliceEncoder)
+	w := new(testWorker)
+	err := ProcessContext(context.Background(), in, out, w, 4)
+	c.Check(err, Equals, decodeErr)
+	c.Check(w.done, Equals, true)
+}
+
+func (s *ProcessSuite) TestEncodeError(c *C) {
+	encodeErr := errors.New("disk full")
+	in := &sliceDecoder{values: intRange(1000)}
+	out := &sliceEncoder{err: encodeErr}
+	err := ProcessContext(context.Background(), in, out, new(testWorker), 4)
+	c.Check(err, Equals, encodeErr)
+}
+
+func (s *ProcessSuite) TestCancel(c *C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	in := &sliceDecoder{values: intRange(1000)}
+	w := &testWorker{handler: func(v interface{}) interface{} {
+		cancel()
+		return v
+	}}
+	err := ProcessContext(ctx, in, new(sliceEncoder), w, 2)
+	c.Check(err, Equals, context.Canceled)
+	c.Check(len(in.values) > 0, Equals, true)
+}
+
+func (s *ProcessSuite) TestOrdered(c *C) {
+	in := &sliceDecoder{values: intRange(500)}
+	out := new(sliceEncoder)
+	w := &testWorker{handler: func(v interface{}) interface{} {
+		if v.(int)%7 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		return v
+	}}
+	config := &Config{Ordered: true, ReorderWindow: 16}
+	err := ProcessConfig(context.Background(), in, out, w, 8, config)
+	c.Assert(err, IsNil)
+	c.Check(out.values, DeepEquals, intRange(500))
+}
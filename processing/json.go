@@ -0,0 +1,74 @@
+package processing
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// JSONLinesDecoder decodes one JSON value per line of input. Blank lines are
+// skipped and a line that is not valid JSON yields a *MalformedRecordError.
+type JSONLinesDecoder struct {
+	// New returns a pointer for each line to be unmarshaled into. When nil,
+	// every line is decoded into a map[string]interface{}.
+	New func() interface{}
+
+	r    *bufio.Reader
+	line int
+}
+
+func NewJSONLinesDecoder(r io.Reader) *JSONLinesDecoder {
+	return &JSONLinesDecoder{
+		r: bufio.NewReader(r),
+	}
+}
+
+func (d *JSONLinesDecoder) DecodeNext() (interface{}, error) {
+	for {
+		b, err := d.r.ReadBytes('\n')
+		if len(b) == 0 && err != nil {
+			return nil, err
+		}
+		d.line++
+		b = bytes.TrimSpace(b)
+		if len(b) == 0 {
+			continue
+		}
+		v, jsonErr := d.unmarshal(b)
+		if jsonErr != nil {
+			return nil, &MalformedRecordError{Line: d.line, Err: jsonErr}
+		}
+		return v, nil
+	}
+}
+
+func (d *JSONLinesDecoder) unmarshal(b []byte) (interface{}, error) {
+	if d.New != nil {
+		v := d.New()
+		if err := json.Unmarshal(b, v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// JSONLinesEncoder writes each value as a single line of JSON.
+type JSONLinesEncoder struct {
+	enc *json.Encoder
+}
+
+func NewJSONLinesEncoder(w io.Writer) *JSONLinesEncoder {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &JSONLinesEncoder{enc: enc}
+}
+
+func (e *JSONLinesEncoder) Encode(v interface{}) error {
+	return e.enc.Encode(v)
+}
@@ -0,0 +1,54 @@
+package processing
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type CodecSuite struct{}
+
+var _ = Suite(&CodecSuite{})
+
+func (s *CodecSuite) TestJSONLinesDecoder(c *C) {
+	in := "{\"ip\":\"1.2.3.4\"}\n\n{bad json\n{\"ip\":\"5.6.7.8\"}"
+	d := NewJSONLinesDecoder(strings.NewReader(in))
+
+	v, err := d.DecodeNext()
+	c.Assert(err, IsNil)
+	c.Check(v, DeepEquals, map[string]interface{}{"ip": "1.2.3.4"})
+
+	_, err = d.DecodeNext()
+	var malformed *MalformedRecordError
+	c.Assert(errors.As(err, &malformed), Equals, true)
+	c.Check(malformed.Line, Equals, 3)
+
+	v, err = d.DecodeNext()
+	c.Assert(err, IsNil)
+	c.Check(v, DeepEquals, map[string]interface{}{"ip": "5.6.7.8"})
+
+	_, err = d.DecodeNext()
+	c.Check(err, Equals, io.EOF)
+}
+
+func (s *CodecSuite) TestJSONLinesDecoderNew(c *C) {
+	type host struct {
+		IP string `json:"ip"`
+	}
+	d := NewJSONLinesDecoder(strings.NewReader("{\"ip\":\"1.2.3.4\"}\n"))
+	d.New = func() interface{} { return new(host) }
+	v, err := d.DecodeNext()
+	c.Assert(err, IsNil)
+	c.Check(v, DeepEquals, &host{IP: "1.2.3.4"})
+}
+
+func (s *CodecSuite) TestJSONLinesEncoder(c *C) {
+	var buf bytes.Buffer
+	e := NewJSONLinesEncoder(&buf)
+	c.Assert(e.Encode(map[string]string{"a": "<b>"}), IsNil)
+	c.Assert(e.Encode(1), IsNil)
+	c.Check(buf.String(), Equals, "{\"a\":\"<b>\"}\n1\n")
+}
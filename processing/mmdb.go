@@ -0,0 +1,420 @@
+package processing
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net/netip"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidMMDB = errors.New("processing: invalid MaxMind DB")
+
+// mmdbMetadataMarker precedes the metadata section at the end of a MaxMind
+// DB file.
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// Data section types of the MaxMind DB format. Types above 7 are stored as
+// extended types.
+const (
+	mmdbExtended = iota
+	mmdbPointer
+	mmdbString
+	mmdbDouble
+	mmdbBytes
+	mmdbUint16
+	mmdbUint32
+	mmdbMap
+	mmdbInt32
+	mmdbUint64
+	mmdbUint128
+	mmdbArray
+	mmdbContainer
+	mmdbEndMarker
+	mmdbBool
+	mmdbFloat
+)
+
+// mmdbMaxDepth bounds the nesting of maps and arrays, so that a corrupt file
+// cannot recurse forever through pointers.
+const mmdbMaxDepth = 64
+
+// mmdbDecoder decodes values of a MaxMind DB data or metadata section, in
+// which pointers are offsets from the start of the section.
+type mmdbDecoder struct {
+	data []byte
+}
+
+// mmdbUint returns the big-endian integer held by b.
+func mmdbUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func (d *mmdbDecoder) bytes(offset, n int) ([]byte, error) {
+	if offset < 0 || n < 0 || offset+n > len(d.data) {
+		return nil, ErrInvalidMMDB
+	}
+	return d.data[offset : offset+n], nil
+}
+
+// pointer returns the target of the pointer whose control byte ctrl
+// precedes offset, and the offset after it.
+func (d *mmdbDecoder) pointer(ctrl byte, offset int) (int, int, error) {
+	ss := int(ctrl>>3) & 3
+	b, err := d.bytes(offset, ss+1)
+	if err != nil {
+		return 0, 0, err
+	}
+	v, high := int(mmdbUint(b)), int(ctrl&7)
+	switch ss {
+	case 0:
+		v |= high << 8
+	case 1:
+		v = (v | high<<16) + 2048
+	case 2:
+		v = (v | high<<24) + 526336
+	}
+	return v, offset + ss + 1, nil
+}
+
+// decode returns the value at offset and the offset after it. Maps are
+// decoded as map[string]interface{}, arrays as []interface{}, unsigned
+// integers up to 64 bits as uint64, uint128 as *big.Int, int32 as int32,
+// doubles as float64 and floats as float32.
+func (d *mmdbDecoder) decode(offset, depth int) (interface{}, int, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, ErrInvalidMMDB
+	}
+	b, err := d.bytes(offset, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	ctrl := b[0]
+	offset++
+	typ := int(ctrl >> 5)
+	if typ == mmdbPointer {
+		target, next, err := d.pointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		if b, err := d.bytes(target, 1); err != nil || b[0]>>5 == mmdbPointer {
+			return nil, 0, ErrInvalidMMDB
+		}
+		v, _, err := d.decode(target, depth+1)
+		return v, next, err
+	}
+	if typ == mmdbExtended {
+		b, err := d.bytes(offset, 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		typ = 7 + int(b[0])
+		offset++
+	}
+	size := int(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		b, err := d.bytes(offset, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		size = []int{29, 285, 65821}[n-1] + int(mmdbUint(b))
+		offset += n
+	}
+
+	switch typ {
+	case mmdbMap:
+		m := make(map[string]interface{}, size)
+		for i := 0; i < size; i++ {
+			var k, v interface{}
+			if k, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, ErrInvalidMMDB
+			}
+			if v, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+		}
+		return m, offset, nil
+	case mmdbArray:
+		a := make([]interface{}, size)
+		for i := range a {
+			if a[i], offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+		}
+		return a, offset, nil
+	case mmdbBool:
+		return size != 0, offset, nil
+	}
+
+	b, err = d.bytes(offset, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset += size
+	switch typ {
+	case mmdbString:
+		return string(b), offset, nil
+	case mmdbBytes:
+		return append([]byte(nil), b...), offset, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, ErrInvalidMMDB
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, ErrInvalidMMDB
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), offset, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64:
+		if size > 8 {
+			return nil, 0, ErrInvalidMMDB
+		}
+		return mmdbUint(b), offset, nil
+	case mmdbInt32:
+		if size > 4 {
+			return nil, 0, ErrInvalidMMDB
+		}
+		return int32(uint32(mmdbUint(b))), offset, nil
+	case mmdbUint128:
+		if size > 16 {
+			return nil, 0, ErrInvalidMMDB
+		}
+		return new(big.Int).SetBytes(b), offset, nil
+	}
+	return nil, 0, fmt.Errorf("%w: unknown data type %d", ErrInvalidMMDB, typ)
+}
+
+// mmdbReader walks the search tree of a MaxMind DB file.
+type mmdbReader struct {
+	tree       []byte
+	data       mmdbDecoder
+	nodeCount  uint
+	recordSize int
+	bits       int
+	// ipv4Start is the node of ::/96 in an IPv6 tree, which IPv4 addresses
+	// are looked up under. Other paths to it, such as ::ffff:0:0/96, are
+	// aliases.
+	ipv4Start uint
+}
+
+func newMMDBReader(b []byte) (*mmdbReader, error) {
+	i := bytes.LastIndex(b, mmdbMetadataMarker)
+	if i < 0 {
+		return nil, fmt.Errorf("%w: no metadata", ErrInvalidMMDB)
+	}
+	meta := mmdbDecoder{data: b[i+len(mmdbMetadataMarker):]}
+	v, _, err := meta.decode(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: metadata is not a map", ErrInvalidMMDB)
+	}
+	nodeCount, _ := m["node_count"].(uint64)
+	recordSize, _ := m["record_size"].(uint64)
+	ipVersion, _ := m["ip_version"].(uint64)
+	r := &mmdbReader{
+		nodeCount:  uint(nodeCount),
+		recordSize: int(recordSize),
+	}
+	switch ipVersion {
+	case 4:
+		r.bits = 32
+	case 6:
+		r.bits = 128
+	default:
+		return nil, fmt.Errorf("%w: IP version %d", ErrInvalidMMDB, ipVersion)
+	}
+	switch r.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("%w: record size %d", ErrInvalidMMDB, r.recordSize)
+	}
+	treeSize := int(nodeCount) * r.recordSize / 4
+	if nodeCount > uint64(len(b)) || treeSize+16 > i {
+		return nil, fmt.Errorf("%w: search tree exceeds file", ErrInvalidMMDB)
+	}
+	r.tree = b[:treeSize]
+	r.data = mmdbDecoder{data: b[treeSize+16 : i]}
+
+	r.ipv4Start = r.nodeCount
+	if r.bits == 128 {
+		node := uint(0)
+		for depth := 0; depth < 96 && node < r.nodeCount; depth++ {
+			node = r.record(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+// record returns the left or right record of node.
+func (r *mmdbReader) record(node uint, right int) uint {
+	b := r.tree[int(node)*r.recordSize/4:]
+	switch r.recordSize {
+	case 24:
+		b = b[right*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if right == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	}
+	return uint(binary.BigEndian.Uint32(b[right*4:]))
+}
+
+// prefixes returns the prefixes that key, the first bits of which form the
+// path to a record, stands for. The IPv4 part of an IPv6 tree is stored
+// under ::/96, and a record above it covers IPv4 as well.
+func (r *mmdbReader) prefixes(key [16]byte, bits int) []netip.Prefix {
+	if r.bits == 32 {
+		return []netip.Prefix{netip.PrefixFrom(netip.AddrFrom4([4]byte(key[:4])), bits)}
+	}
+	if [12]byte(key[:12]) != [12]byte{} {
+		return []netip.Prefix{netip.PrefixFrom(netip.AddrFrom16(key), bits)}
+	}
+	if bits >= 96 {
+		return []netip.Prefix{netip.PrefixFrom(netip.AddrFrom4([4]byte(key[12:])), bits-96)}
+	}
+	return []netip.Prefix{
+		netip.PrefixFrom(netip.AddrFrom16(key), bits),
+		netip.PrefixFrom(netip.IPv4Unspecified(), 0),
+	}
+}
+
+// walk calls emit with every prefix below node, whose path is the first
+// depth bits of key, and the data section offset of its record.
+func (r *mmdbReader) walk(node uint, key [16]byte, depth int, emit func(netip.Prefix, int) error) error {
+	if node >= r.nodeCount || depth >= r.bits {
+		return ErrInvalidMMDB
+	}
+	for right := 0; right < 2; right++ {
+		k := key
+		if right == 1 {
+			k[depth/8] |= 0x80 >> (depth % 8)
+		}
+		rec := r.record(node, right)
+		switch {
+		case rec < r.nodeCount:
+			if rec == r.ipv4Start && (depth+1 != 96 || k != [16]byte{}) {
+				continue
+			}
+			if err := r.walk(rec, k, depth+1, emit); err != nil {
+				return err
+			}
+		case rec > r.nodeCount:
+			offset := int(rec - r.nodeCount - 16)
+			for _, prefix := range r.prefixes(k, depth+1) {
+				if err := emit(prefix, offset); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// flattenMMDB adds the leaves of v to fields under their dotted paths, such
+// as country.iso_code or subdivisions.0.names.en. A value that is not a map
+// or an array is added as "value".
+func flattenMMDB(path string, v interface{}, fields map[string]string) {
+	join := func(name string) string {
+		if path == "" {
+			return name
+		}
+		return path + "." + name
+	}
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for name, value := range x {
+			flattenMMDB(join(name), value, fields)
+		}
+		return
+	case []interface{}:
+		for i, value := range x {
+			flattenMMDB(join(strconv.Itoa(i)), value, fields)
+		}
+		return
+	}
+	if path == "" {
+		path = "value"
+	}
+	switch x := v.(type) {
+	case string:
+		fields[path] = x
+	case []byte:
+		fields[path] = base64.StdEncoding.EncodeToString(x)
+	case uint64:
+		fields[path] = strconv.FormatUint(x, 10)
+	case int32:
+		fields[path] = strconv.FormatInt(int64(x), 10)
+	case float64:
+		fields[path] = strconv.FormatFloat(x, 'f', -1, 64)
+	case float32:
+		fields[path] = strconv.FormatFloat(float64(x), 'f', -1, 32)
+	case bool:
+		fields[path] = strconv.FormatBool(x)
+	case *big.Int:
+		fields[path] = x.String()
+	}
+}
+
+// LoadMMDB adds the networks of a MaxMind DB database, such as GeoLite2-ASN
+// or GeoLite2-Country, to t. The data of each network is flattened into
+// fields named by their dotted paths, such as autonomous_system_number,
+// country.iso_code or country.names.en. Networks that share their data share
+// the same fields, which must not be modified.
+func (t *PrefixTable) LoadMMDB(r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	db, err := newMMDBReader(b)
+	if err != nil {
+		return err
+	}
+	cache := make(map[int]map[string]string)
+	return db.walk(0, [16]byte{}, 0, func(prefix netip.Prefix, offset int) error {
+		fields, ok := cache[offset]
+		if !ok {
+			v, _, err := db.data.decode(offset, 0)
+			if err != nil {
+				return err
+			}
+			fields = make(map[string]string)
+			flattenMMDB("", v, fields)
+			cache[offset] = fields
+		}
+		t.Insert(prefix, fields)
+		return nil
+	})
+}
+
+// isMMDB reports whether the named file is a MaxMind DB database, by its
+// extension, possibly followed by a compression extension.
+func isMMDB(name string) bool {
+	if CompressionForName(name) != CompressionNone {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return strings.EqualFold(filepath.Ext(name), ".mmdb")
+}
@@ -0,0 +1,268 @@
+package processing
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+
+	. "gopkg.in/check.v1"
+)
+
+type MMDBSuite struct{}
+
+var _ = Suite(&MMDBSuite{})
+
+// mmdbRef is a pointer to an earlier value of a data section.
+type mmdbRef int
+
+// mmdbControl encodes the control bytes of a value shorter than 285 bytes.
+func mmdbControl(typ, size int) []byte {
+	var extra []byte
+	if size >= 29 {
+		extra = []byte{byte(size - 29)}
+		size = 29
+	}
+	if typ > 7 {
+		return append([]byte{byte(size), byte(typ - 7)}, extra...)
+	}
+	return append([]byte{byte(typ<<5 | size)}, extra...)
+}
+
+// mmdbValue encodes a data section value: a string, a uint32, a bool, an
+// array or map of them, with map keys in sorted order, or an mmdbRef.
+func mmdbValue(v interface{}) []byte {
+	switch x := v.(type) {
+	case string:
+		return append(mmdbControl(mmdbString, len(x)), x...)
+	case uint32:
+		b := []byte{byte(x >> 24), byte(x >> 16), byte(x >> 8), byte(x)}
+		for len(b) > 0 && b[0] == 0 {
+			b = b[1:]
+		}
+		return append(mmdbControl(mmdbUint32, len(b)), b...)
+	case bool:
+		size := 0
+		if x {
+			size = 1
+		}
+		return mmdbControl(mmdbBool, size)
+	case []interface{}:
+		b := mmdbControl(mmdbArray, len(x))
+		for _, e := range x {
+			b = append(b, mmdbValue(e)...)
+		}
+		return b
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b := mmdbControl(mmdbMap, len(x))
+		for _, k := range keys {
+			b = append(b, mmdbValue(k)...)
+			b = append(b, mmdbValue(x[k])...)
+		}
+		return b
+	case mmdbRef:
+		return []byte{byte(mmdbPointer<<5 | int(x)>>8), byte(x)}
+	}
+	panic("unsupported value")
+}
+
+type mmdbTestNode struct {
+	children [2]*mmdbTestNode
+	data     int
+}
+
+// mmdbTestWriter builds a MaxMind DB file from networks mapped to values of
+// its data section.
+type mmdbTestWriter struct {
+	root mmdbTestNode
+	data []byte
+}
+
+func (w *mmdbTestWriter) add(v interface{}) int {
+	offset := len(w.data)
+	w.data = append(w.data, mmdbValue(v)...)
+	return offset
+}
+
+// node returns the node at the first bits of key, creating it if needed.
+func (w *mmdbTestWriter) node(key [16]byte, bits int) *mmdbTestNode {
+	n := &w.root
+	for i := 0; i < bits; i++ {
+		b := bit(key, i)
+		if n.children[b] == nil {
+			n.children[b] = &mmdbTestNode{data: -1}
+		}
+		n = n.children[b]
+	}
+	return n
+}
+
+func (w *mmdbTestWriter) insert(prefix string, data int) {
+	p := netip.MustParsePrefix(prefix)
+	key, bits := p.Addr().As16(), p.Bits()
+	if p.Addr().Is4() {
+		// IPv4 networks live under ::/96.
+		key = [16]byte{}
+		copy(key[12:], p.Addr().AsSlice())
+		bits += 96
+	}
+	w.node(key, bits).data = data
+}
+
+// alias makes ::ffff:0:0/96 lead to the IPv4 networks under ::/96.
+func (w *mmdbTestWriter) alias() {
+	ipv4 := w.node([16]byte{}, 96)
+	mapped := netip.MustParseAddr("::ffff:0:0").As16()
+	w.node(mapped, 95).children[bit(mapped, 95)] = ipv4
+}
+
+func (w *mmdbTestWriter) bytes(recordSize int) []byte {
+	var nodes []*mmdbTestNode
+	numbers := make(map[*mmdbTestNode]int)
+	var number func(n *mmdbTestNode)
+	number = func(n *mmdbTestNode) {
+		if _, ok := numbers[n]; ok || n.data >= 0 {
+			return
+		}
+		numbers[n] = len(nodes)
+		nodes = append(nodes, n)
+		for _, child := range n.children {
+			if child != nil {
+				number(child)
+			}
+		}
+	}
+	w.root.data = -1
+	number(&w.root)
+
+	count := len(nodes)
+	record := func(n *mmdbTestNode) uint32 {
+		switch {
+		case n == nil:
+			return uint32(count)
+		case n.data >= 0:
+			return uint32(count + 16 + n.data)
+		}
+		return uint32(numbers[n])
+	}
+	var out []byte
+	for _, n := range nodes {
+		l, r := record(n.children[0]), record(n.children[1])
+		switch recordSize {
+		case 24:
+			out = append(out, byte(l>>16), byte(l>>8), byte(l), byte(r>>16), byte(r>>8), byte(r))
+		case 28:
+			out = append(out, byte(l>>16), byte(l>>8), byte(l), byte(l>>20&0xf0|r>>24&0x0f), byte(r>>16), byte(r>>8), byte(r))
+		}
+	}
+	out = append(out, make([]byte, 16)...)
+	out = append(out, w.data...)
+	out = append(out, mmdbMetadataMarker...)
+	meta := map[string]interface{}{
+		"node_count":  uint32(count),
+		"record_size": uint32(recordSize),
+		"ip_version":  uint32(6),
+	}
+	return append(out, mmdbValue(meta)...)
+}
+
+func newTestMMDB() *mmdbTestWriter {
+	w := new(mmdbTestWriter)
+	nl := w.add(map[string]interface{}{"iso_code": "NL", "eu": true})
+	asn := w.add(map[string]interface{}{
+		"autonomous_system_number":       uint32(64500),
+		"autonomous_system_organization": "Example",
+		"country":                        mmdbRef(nl),
+	})
+	w.insert("192.0.2.0/24", asn)
+	w.insert("198.51.100.0/25", w.add(map[string]interface{}{
+		"autonomous_system_number": uint32(64501),
+		"subdivisions":             []interface{}{"NH", "ZH"},
+	}))
+	w.insert("2001:db8::/32", w.add(map[string]interface{}{
+		"autonomous_system_number": uint32(64510),
+		"country":                  mmdbRef(nl),
+	}))
+	w.alias()
+	return w
+}
+
+func (s *MMDBSuite) TestLoadMMDB(c *C) {
+	for _, recordSize := range []int{24, 28} {
+		t := NewPrefixTable()
+		c.Assert(t.LoadMMDB(bytes.NewReader(newTestMMDB().bytes(recordSize))), IsNil)
+		// The alias under ::ffff:0:0/96 adds no networks.
+		c.Check(t.Len(), Equals, 3)
+		for addr, want := range map[string]map[string]string{
+			"192.0.2.77": {
+				"autonomous_system_number":       "64500",
+				"autonomous_system_organization": "Example",
+				"country.iso_code":               "NL",
+				"country.eu":                     "true",
+			},
+			"198.51.100.1": {
+				"autonomous_system_number": "64501",
+				"subdivisions.0":           "NH",
+				"subdivisions.1":           "ZH",
+			},
+			"2001:db8:1::1": {
+				"autonomous_system_number": "64510",
+				"country.iso_code":         "NL",
+				"country.eu":               "true",
+			},
+			"198.51.100.200": nil,
+			"::c000:24d":     nil,
+		} {
+			fields, ok := t.Lookup(netip.MustParseAddr(addr))
+			c.Check(ok, Equals, want != nil, Commentf("%s/%d", addr, recordSize))
+			if want != nil {
+				c.Check(fields, DeepEquals, want, Commentf("%s/%d", addr, recordSize))
+			}
+		}
+	}
+}
+
+func (s *MMDBSuite) TestInvalidMMDB(c *C) {
+	b := newTestMMDB().bytes(24)
+	for _, corrupt := range [][]byte{
+		nil,
+		b[:len(b)/2],
+	} {
+		err := NewPrefixTable().LoadMMDB(bytes.NewReader(corrupt))
+		c.Check(errors.Is(err, ErrInvalidMMDB), Equals, true)
+	}
+	// A pointer to itself.
+	w := new(mmdbTestWriter)
+	w.insert("192.0.2.0/24", w.add(mmdbRef(0)))
+	err := NewPrefixTable().LoadMMDB(bytes.NewReader(w.bytes(24)))
+	c.Check(errors.Is(err, ErrInvalidMMDB), Equals, true)
+}
+
+func (s *MMDBSuite) TestLoadPrefixFiles(c *C) {
+	dir := c.MkDir()
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(newTestMMDB().bytes(24))
+	c.Assert(zw.Close(), IsNil)
+	mmdb := filepath.Join(dir, "asn.mmdb.gz")
+	c.Assert(os.WriteFile(mmdb, gz.Bytes(), 0644), IsNil)
+	csv := filepath.Join(dir, "override.csv")
+	c.Assert(os.WriteFile(csv, []byte("network,autonomous_system_number\n192.0.2.0/24,64999\n"), 0644), IsNil)
+
+	t, err := LoadPrefixFiles(mmdb, csv)
+	c.Assert(err, IsNil)
+	fields, ok := t.Lookup(netip.MustParseAddr("192.0.2.1"))
+	c.Assert(ok, Equals, true)
+	c.Check(fields, DeepEquals, map[string]string{"autonomous_system_number": "64999"})
+	fields, ok = t.Lookup(netip.MustParseAddr("2001:db8::1"))
+	c.Assert(ok, Equals, true)
+	c.Check(fields["autonomous_system_number"], Equals, "64510")
+}
@@ -0,0 +1,32 @@
+package processing
+
+// reorderBuffer releases values in sequence order, holding back any value
+// that arrives before all of its predecessors.
+type reorderBuffer[T any] struct {
+	next    uint64
+	pending map[uint64]T
+}
+
+func newReorderBuffer[T any](start uint64) *reorderBuffer[T] {
+	return &reorderBuffer[T]{next: start, pending: make(map[uint64]T)}
+}
+
+// push adds v with sequence number seq to the buffer and calls emit for
+// every value that is now contiguous with the values already released.
+func (b *reorderBuffer[T]) push(seq uint64, v T, emit func(T)) {
+	if seq != b.next {
+		b.pending[seq] = v
+		return
+	}
+	emit(v)
+	b.next++
+	for {
+		v, ok := b.pending[b.next]
+		if !ok {
+			return
+		}
+		delete(b.pending, b.next)
+		emit(v)
+		b.next++
+	}
+}
@@ -0,0 +1,155 @@
+package processing
+
+import (
+	"context"
+	"io"
+)
+
+// TypedDecoder is the type-safe counterpart of Decoder.
+type TypedDecoder[In any] interface {
+	DecodeNext() (In, error)
+}
+
+// TypedEncoder is the type-safe counterpart of Encoder.
+type TypedEncoder[Out any] interface {
+	Encode(v Out) error
+}
+
+// TypedHandler is the type-safe counterpart of Handler. A non-nil error marks
+// the record as failed: the run wraps it in a *RecordError carrying the input,
+// unless it already is one, and routes it like any other failure. The one
+// exception is ErrSkipRecord, which drops the record.
+type TypedHandler[In, Out any] func(In) (Out, error)
+
+// TypedWorker is the type-safe counterpart of Worker.
+type TypedWorker[In, Out any] interface {
+	MakeHandler(uint) TypedHandler[In, Out]
+	Success() uint
+	Failure() uint
+	Total() uint
+	Done()
+}
+
+// Pipeline decodes records of type In, passes each one to a handler created
+// by Worker, and encodes the results of type Out. It is the engine behind
+// Process, which runs a Pipeline[interface{}, interface{}].
+//
+// Failed records are written to Config.DeadLetter if it is set. Otherwise
+// they are written to Encoder when a *RecordError is assignable to Out, and
+// dropped when it is not.
+type Pipeline[In, Out any] struct {
+	Decoder TypedDecoder[In]
+	Encoder TypedEncoder[Out]
+	Worker  TypedWorker[In, Out]
+	Workers uint
+	Config  *Config
+}
+
+// Run runs the pipeline until the input is exhausted, ctx is cancelled, or
+// the first decode or encode error, and returns that error. All goroutines
+// it started have exited by the time it returns, except those running
+// handler calls abandoned after Config.HandlerTimeout or cancellation, which
+// exit once the calls return.
+func (pl *Pipeline[In, Out]) Run(ctx context.Context) error {
+	return newProcess(pl).run(ctx)
+}
+
+// typedEncoder adapts an Encoder to a TypedEncoder. Flush and Close are
+// forwarded if the Encoder has them, and do nothing otherwise.
+type typedEncoder[Out any] struct {
+	Encoder
+}
+
+func (e typedEncoder[Out]) Encode(v Out) error {
+	return e.Encoder.Encode(v)
+}
+
+func (e typedEncoder[Out]) Flush() error {
+	if f, ok := e.Encoder.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+func (e typedEncoder[Out]) Close() error {
+	if c, ok := e.Encoder.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// typedBatchEncoder adapts a BatchEncoder to a TypedBatchEncoder.
+type typedBatchEncoder[Out any] struct {
+	BatchEncoder
+}
+
+func (e typedBatchEncoder[Out]) Encode(v Out) error {
+	return e.BatchEncoder.Encode(v)
+}
+
+func (e typedBatchEncoder[Out]) EncodeBatch(vs []Out) error {
+	batch := make([]interface{}, len(vs))
+	for i, v := range vs {
+		batch[i] = v
+	}
+	return e.BatchEncoder.EncodeBatch(batch)
+}
+
+// NewTypedEncoder adapts an untyped Encoder, such as a JSONLinesEncoder, for
+// use as the encoder of a Pipeline or a Sink. A BatchEncoder stays one, and
+// the Flush and Close methods of other encoders are kept, so that the
+// Pipeline batches, flushes and closes it just as Process would.
+func NewTypedEncoder[Out any](e Encoder) TypedEncoder[Out] {
+	if b, ok := e.(BatchEncoder); ok {
+		return typedBatchEncoder[Out]{b}
+	}
+	return typedEncoder[Out]{e}
+}
+
+// TypedJSONLinesDecoder decodes one JSON value of type T per line of input.
+type TypedJSONLinesDecoder[T any] struct {
+	d *JSONLinesDecoder
+}
+
+func NewTypedJSONLinesDecoder[T any](r io.Reader) *TypedJSONLinesDecoder[T] {
+	d := NewJSONLinesDecoder(r)
+	d.New = func() interface{} {
+		return new(T)
+	}
+	return &TypedJSONLinesDecoder[T]{d: d}
+}
+
+func (t *TypedJSONLinesDecoder[T]) DecodeNext() (*T, error) {
+	v, err := t.d.DecodeNext()
+	if err != nil {
+		return nil, err
+	}
+	return v.(*T), nil
+}
+
+// untypedWorker adapts a Worker to a TypedWorker. Handler results that are a
+// *RecordError, as returned through Fail, become handler errors, and Skip
+// becomes ErrSkipRecord.
+type untypedWorker struct {
+	Worker
+}
+
+func (w untypedWorker) MakeHandler(id uint) TypedHandler[interface{}, interface{}] {
+	handler := w.Worker.MakeHandler(id)
+	return func(v interface{}) (interface{}, error) {
+		result := handler(v)
+		if result == Skip {
+			return nil, ErrSkipRecord
+		}
+		if rerr, ok := result.(*RecordError); ok {
+			return nil, rerr
+		}
+		return result, nil
+	}
+}
+
+func (w untypedWorker) RecordFailure(rerr *RecordError) {
+	if fr, ok := w.Worker.(FailureRecorder); ok {
+		fr.RecordFailure(rerr)
+	}
+}
@@ -0,0 +1,127 @@
+package processing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type PipelineSuite struct{}
+
+var _ = Suite(&PipelineSuite{})
+
+type host struct {
+	IP   string `json:"ip"`
+	Port int    `json:"port"`
+}
+
+type address struct {
+	Address string `json:"address"`
+}
+
+type hostWorker struct {
+	Counters
+}
+
+func (w *hostWorker) MakeHandler(uint) TypedHandler[*host, address] {
+	return func(h *host) (address, error) {
+		if h.Port == 0 {
+			w.AddFailure()
+			return address{}, errors.New("missing port")
+		}
+		w.AddSuccess()
+		return address{Address: h.IP + ":" + strconv.Itoa(h.Port)}, nil
+	}
+}
+
+func (w *hostWorker) Done() {}
+
+func (s *PipelineSuite) TestTypedPipeline(c *C) {
+	in := "{\"ip\":\"1.2.3.4\",\"port\":443}\n{\"ip\":\"5.6.7.8\"}\n{\"ip\":\"9.9.9.9\",\"port\":80}\n"
+	var out bytes.Buffer
+	dead := new(sliceEncoder)
+	w := new(hostWorker)
+	pl := &Pipeline[*host, address]{
+		Decoder: NewTypedJSONLinesDecoder[host](strings.NewReader(in)),
+		Encoder: NewTypedEncoder[address](NewJSONLinesEncoder(&out)),
+		Worker:  w,
+		Workers: 2,
+		Config:  &Config{Ordered: true, DeadLetter: dead},
+	}
+	c.Assert(pl.Run(context.Background()), IsNil)
+	c.Check(out.String(), Equals, "{\"address\":\"1.2.3.4:443\"}\n{\"address\":\"9.9.9.9:80\"}\n")
+	c.Check(w.Success(), Equals, uint(2))
+	c.Check(w.Failure(), Equals, uint(1))
+	c.Assert(dead.values, HasLen, 1)
+	rerr := dead.values[0].(*RecordError)
+	c.Check(rerr.Input, DeepEquals, &host{IP: "5.6.7.8"})
+	c.Check(rerr.Error(), Equals, "missing port")
+}
+
+func (s *PipelineSuite) TestTypedFailuresWithoutDeadLetter(c *C) {
+	in := "{\"ip\":\"1.2.3.4\",\"port\":443}\n{\"ip\":\"5.6.7.8\"}\n"
+	var out bytes.Buffer
+	pl := &Pipeline[*host, address]{
+		Decoder: NewTypedJSONLinesDecoder[host](strings.NewReader(in)),
+		Encoder: NewTypedEncoder[address](NewJSONLinesEncoder(&out)),
+		Worker:  new(hostWorker),
+	}
+	c.Assert(pl.Run(context.Background()), IsNil)
+	c.Check(out.String(), Equals, "{\"address\":\"1.2.3.4:443\"}\n")
+}
+
+func (s *PipelineSuite) TestTypedCheckpointed(c *C) {
+	dir := c.MkDir()
+	name := filepath.Join(dir, "out.json")
+	in := "{\"ip\":\"1.2.3.4\",\"port\":443}\n{\"ip\":\"5.6.7.8\"}\n{\"ip\":\"9.9.9.9\",\"port\":80}\n"
+	f, err := CreateJSONLinesFile(name)
+	c.Assert(err, IsNil)
+	cc := &CheckpointConfig{
+		Path: filepath.Join(dir, "out.checkpoint"),
+		Sync: func() (int64, error) {
+			info, err := os.Stat(name)
+			if err != nil {
+				return 0, err
+			}
+			return info.Size(), nil
+		},
+	}
+	pl := &Pipeline[*host, address]{
+		Decoder: NewTypedJSONLinesDecoder[host](strings.NewReader(in)),
+		Encoder: NewTypedEncoder[address](f),
+		Worker:  new(hostWorker),
+		Workers: 2,
+		Config:  &Config{Checkpoint: cc, DeadLetter: new(sliceEncoder)},
+	}
+	c.Assert(pl.Run(context.Background()), IsNil)
+
+	want := "{\"address\":\"1.2.3.4:443\"}\n{\"address\":\"9.9.9.9:80\"}\n"
+	b, err := os.ReadFile(name)
+	c.Assert(err, IsNil)
+	c.Check(string(b), Equals, want)
+	cp, err := LoadCheckpoint(cc.Path)
+	c.Assert(err, IsNil)
+	c.Check(cp.Records, Equals, uint64(3))
+	c.Check(cp.Offset, Equals, int64(len(want)))
+}
+
+func (s *PipelineSuite) TestTypedAggregator(c *C) {
+	in := "{\"ip\":\"1.2.3.4\",\"port\":443}\n{\"ip\":\"9.9.9.9\",\"port\":80}\n"
+	var report bytes.Buffer
+	agg := NewAggregator().Add("address", FieldPath("address"), NewCounter())
+	agg.Output = &report
+	pl := &Pipeline[*host, address]{
+		Decoder: NewTypedJSONLinesDecoder[host](strings.NewReader(in)),
+		Encoder: NewTypedEncoder[address](agg),
+		Worker:  new(hostWorker),
+	}
+	c.Assert(pl.Run(context.Background()), IsNil)
+	c.Check(agg.Reducer("address").(*Counter).Count("9.9.9.9:80"), Equals, uint64(1))
+	c.Check(strings.Contains(report.String(), "\"records\": 2"), Equals, true)
+}
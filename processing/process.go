@@ -0,0 +1,525 @@
+package processing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultReorderWindow is the reorder window used by ordered runs when
+	// Config.ReorderWindow is zero.
+	DefaultReorderWindow = 1024
+	// DefaultBatchSize and DefaultBatchLatency are used when the
+	// corresponding Config fields are zero.
+	DefaultBatchSize    = 512
+	DefaultBatchLatency = time.Second
+)
+
+// Config controls the optional behaviour of ProcessConfig and Pipeline. A
+// nil *Config behaves like the zero value.
+type Config struct {
+	// Ordered makes Process write results in input order rather than in the
+	// order the workers finish them.
+	Ordered bool
+	// ReorderWindow bounds how many records an ordered run may read ahead of
+	// the oldest record that has not been written yet.
+	ReorderWindow uint
+	// Checkpoint enables periodic checkpoints and resuming from them.
+	// Checkpointing implies Ordered.
+	Checkpoint *CheckpointConfig
+	// Progress enables periodic progress reports.
+	Progress *ProgressConfig
+	// Adaptive, if set, varies the number of workers during the run
+	// instead of keeping it fixed.
+	Adaptive *AdaptiveConfig
+	// PropagatePanics lets a panicking handler call crash the program. By
+	// default the panic is recovered, and the record fails with a
+	// *RecordError wrapping a *PanicError.
+	PropagatePanics bool
+	// HandlerTimeout, if positive, abandons handler calls that take longer
+	// and emits a *RecordError wrapping ErrHandlerTimeout instead. The worker
+	// continues with a new handler from MakeHandler, under a new id, since
+	// the abandoned call may still be running; its id is reused once the call
+	// returns. Like panics, timeouts are counted only by a Worker that
+	// implements FailureRecorder. HandlerTimeout overrides PropagatePanics.
+	HandlerTimeout time.Duration
+	// DeadLetter, if set, receives every *RecordError result, whether
+	// returned by a handler through Fail or produced by a panic or timeout,
+	// instead of the output encoder. Checkpoints do not record how much has
+	// been written to it, so a resumed run may write some failures again.
+	// It is flushed at the end of the run if it has a Flush method, but not
+	// closed. Input the decoder reports as a *MalformedRecordError is
+	// written to it as a *RecordError too, and reading continues.
+	DeadLetter Encoder
+	// SkipMalformed drops input the decoder reports as a
+	// *MalformedRecordError and continues reading, when there is no
+	// DeadLetter to write it to. Without either, a malformed record ends the
+	// run with that error. Dropped records still count towards checkpoints.
+	SkipMalformed bool
+	// RateLimit, if set, is waited on by the workers before each record is
+	// handled. It is shared by all of them.
+	RateLimit Limiter
+	// BatchSize and BatchLatency control how results are grouped when the
+	// output is a BatchEncoder: a batch is written once it holds BatchSize
+	// results, and at least every BatchLatency.
+	BatchSize    int
+	BatchLatency time.Duration
+}
+
+func (c *Config) ordered() bool {
+	return c.Ordered || c.Checkpoint != nil
+}
+
+// tolerates reports whether the run continues after decode error err,
+// because it is a *MalformedRecordError that is dropped or dead-lettered.
+func (c *Config) tolerates(err error) bool {
+	var merr *MalformedRecordError
+	return (c.SkipMalformed || c.DeadLetter != nil) && errors.As(err, &merr)
+}
+
+func (c *Config) batchSize() int {
+	if c.BatchSize <= 0 {
+		return DefaultBatchSize
+	}
+	return c.BatchSize
+}
+
+func (c *Config) batchLatency() time.Duration {
+	if c.BatchLatency <= 0 {
+		return DefaultBatchLatency
+	}
+	return c.BatchLatency
+}
+
+func (c *Config) reorderWindow() uint {
+	if c.ReorderWindow == 0 {
+		return DefaultReorderWindow
+	}
+	return c.ReorderWindow
+}
+
+// record is a decoded input value tagged with its position in the input.
+type record[T any] struct {
+	seq   uint64
+	value T
+}
+
+// result is the outcome of handling the input record at position seq:
+// either a value, or err if the record failed, or nothing if the handler
+// skipped it.
+type result[T any] struct {
+	seq   uint64
+	value T
+	err   *RecordError
+	skip  bool
+}
+
+// process holds the state shared by the reader, worker and output
+// goroutines of a single Pipeline run.
+type process[In, Out any] struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	config *Config
+
+	in  TypedDecoder[In]
+	out TypedEncoder[Out]
+	w   TypedWorker[In, Out]
+	// initial is the number of workers started with. Without an adaptive
+	// configuration it never changes.
+	initial uint
+
+	processQueue chan record[In]
+	outputQueue  chan result[Out]
+	// In ordered mode every record holds a slot in window from the moment it
+	// is read until its result has been written.
+	window chan struct{}
+
+	// start is the sequence number of the first record read by this run,
+	// which is non-zero when resuming from a checkpoint.
+	start uint64
+
+	// read and written count the records read and the results encoded by
+	// this run.
+	read    atomic.Uint64
+	written atomic.Uint64
+
+	// ids hands out handler ids, and workers tracks the worker goroutines.
+	// Adaptive runs stop a worker by sending on shrink, and use active,
+	// busy and handled to decide when to do so.
+	ids     idPool
+	workers sync.WaitGroup
+	active  atomic.Int64
+	shrink  chan struct{}
+	busy    atomic.Int64
+	handled atomic.Uint64
+	// handlerMu serializes the calls to MakeHandler.
+	handlerMu sync.Mutex
+
+	errOnce  sync.Once
+	firstErr error
+}
+
+func newProcess[In, Out any](pl *Pipeline[In, Out]) *process[In, Out] {
+	config := pl.Config
+	if config == nil {
+		config = new(Config)
+	}
+	workers := pl.Workers
+	if workers == 0 {
+		workers = 1
+	}
+	queue := workers * 4
+	if ac := config.Adaptive; ac != nil {
+		workers = ac.clamp(pl.Workers)
+		queue = ac.maxWorkers() * 4
+	}
+	p := &process[In, Out]{
+		config:       config,
+		in:           pl.Decoder,
+		out:          pl.Encoder,
+		w:            pl.Worker,
+		initial:      workers,
+		processQueue: make(chan record[In], queue),
+		outputQueue:  make(chan result[Out], queue),
+	}
+	if config.Adaptive != nil {
+		p.shrink = make(chan struct{}, config.Adaptive.maxWorkers())
+	}
+	if config.ordered() {
+		p.window = make(chan struct{}, config.reorderWindow())
+	}
+	return p
+}
+
+func (p *process[In, Out]) run(ctx context.Context) error {
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	defer p.cancel()
+	if err := p.resume(); err != nil {
+		p.w.Done()
+		return err
+	}
+
+	outputDone := make(chan int, 1)
+	go func() {
+		p.runOutput()
+		outputDone <- 1
+	}()
+	var progressDone chan int
+	stopProgress := make(chan struct{})
+	if p.config.Progress != nil {
+		progressDone = make(chan int, 1)
+		go func() {
+			p.reportProgress(stopProgress)
+			progressDone <- 1
+		}()
+	}
+	for i := uint(0); i < p.initial; i++ {
+		p.startWorker()
+	}
+	var adaptDone chan int
+	stopAdapt := make(chan struct{})
+	if p.config.Adaptive != nil {
+		adaptDone = make(chan int, 1)
+		go func() {
+			p.adapt(stopAdapt)
+			adaptDone <- 1
+		}()
+	}
+	p.readInput()
+	close(p.processQueue)
+	close(stopAdapt)
+	if adaptDone != nil {
+		<-adaptDone
+	}
+	p.workers.Wait()
+	close(p.outputQueue)
+	<-outputDone
+	close(stopProgress)
+	if progressDone != nil {
+		<-progressDone
+	}
+	p.w.Done()
+
+	p.errOnce.Do(func() {
+		p.firstErr = p.ctx.Err()
+	})
+	return p.firstErr
+}
+
+// fail records the first error of the run and cancels it.
+func (p *process[In, Out]) fail(err error) {
+	p.errOnce.Do(func() {
+		p.firstErr = err
+		p.cancel()
+	})
+}
+
+// resume skips the input records covered by an existing checkpoint.
+func (p *process[In, Out]) resume() error {
+	cc := p.config.Checkpoint
+	if cc == nil || !cc.Resume {
+		return nil
+	}
+	if cc.Sync == nil {
+		return ErrResumeWithoutSync
+	}
+	cp, err := LoadCheckpoint(cc.Path)
+	if err != nil {
+		return err
+	}
+	if cp.Records > 0 && cp.Offset < 0 {
+		return ErrNoOutputOffset
+	}
+	for p.start < cp.Records {
+		if _, err := p.in.DecodeNext(); err != nil {
+			if err == io.EOF {
+				break
+			}
+			if !p.config.tolerates(err) {
+				return err
+			}
+		}
+		p.start++
+	}
+	return nil
+}
+
+func (p *process[In, Out]) readInput() {
+	seq := p.start
+	for p.ctx.Err() == nil {
+		obj, err := p.in.DecodeNext()
+		if err == io.EOF {
+			return
+		}
+		if err != nil && !p.config.tolerates(err) {
+			p.fail(err)
+			return
+		}
+		if p.window != nil {
+			select {
+			case p.window <- struct{}{}:
+			case <-p.ctx.Done():
+				return
+			}
+		}
+		if err != nil {
+			// A malformed record bypasses the workers, but keeps its place
+			// in the input order.
+			r := result[Out]{seq: seq, skip: p.config.DeadLetter == nil}
+			if !r.skip {
+				r.err = &RecordError{Err: err}
+			}
+			select {
+			case p.outputQueue <- r:
+				seq++
+				p.read.Add(1)
+			case <-p.ctx.Done():
+				return
+			}
+			continue
+		}
+		select {
+		case p.processQueue <- record[In]{seq, obj}:
+			seq++
+			p.read.Add(1)
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// encode writes a single result to the encoder it is routed to.
+func (p *process[In, Out]) encode(r result[Out]) error {
+	if r.err == nil {
+		return p.out.Encode(r.value)
+	}
+	if p.config.DeadLetter != nil {
+		return p.config.DeadLetter.Encode(r.err)
+	}
+	if v, ok := interface{}(r.err).(Out); ok {
+		return p.out.Encode(v)
+	}
+	return nil
+}
+
+// runOutput encodes results until the output queue is closed. It keeps
+// draining the queue after an error so that no worker blocks on a send. If
+// the output is a batch encoder, results are collected into batches, and
+// the encoder is flushed and closed once the queue is closed, whether or not
+// the run succeeded.
+func (p *process[In, Out]) runOutput() {
+	var (
+		failed  bool
+		encoded = p.start
+		pending []result[Out]
+	)
+	batch, _ := p.out.(TypedBatchEncoder[Out])
+	write := func(r result[Out]) {
+		if failed {
+			return
+		}
+		if r.skip {
+			encoded++
+			return
+		}
+		if err := p.encode(r); err != nil {
+			failed = true
+			p.fail(err)
+			return
+		}
+		encoded++
+		p.written.Add(1)
+	}
+	flush := func() {
+		if failed || len(pending) == 0 {
+			return
+		}
+		n, err := p.encodeBatch(batch, pending)
+		if err != nil {
+			failed = true
+			p.fail(err)
+			return
+		}
+		encoded += uint64(len(pending))
+		p.written.Add(uint64(n))
+		pending = pending[:0]
+	}
+	emit := write
+	if batch != nil {
+		emit = func(r result[Out]) {
+			pending = append(pending, r)
+			if len(pending) >= p.config.batchSize() {
+				flush()
+			}
+		}
+	}
+
+	var tick, flushTick <-chan time.Time
+	if cc := p.config.Checkpoint; cc != nil {
+		ticker := time.NewTicker(cc.interval())
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	if batch != nil {
+		ticker := time.NewTicker(p.config.batchLatency())
+		defer ticker.Stop()
+		flushTick = ticker.C
+	}
+
+	var reorder *reorderBuffer[result[Out]]
+	if p.window != nil {
+		reorder = newReorderBuffer[result[Out]](p.start)
+	}
+	for {
+		select {
+		case r, ok := <-p.outputQueue:
+			if !ok {
+				flush()
+				if p.config.Checkpoint != nil && !failed {
+					p.checkpoint(encoded)
+				}
+				if batch != nil {
+					p.closeBatch(batch)
+				} else {
+					p.flushOutput(p.out)
+				}
+				p.flushOutput(p.config.DeadLetter)
+				return
+			}
+			if reorder == nil {
+				emit(r)
+				continue
+			}
+			reorder.push(r.seq, r, func(r result[Out]) {
+				emit(r)
+				<-p.window
+			})
+		case <-flushTick:
+			flush()
+		case <-tick:
+			flush()
+			if !failed {
+				p.checkpoint(encoded)
+			}
+		}
+	}
+}
+
+// encodeBatch writes a batch of results, sending failures to the dead-letter
+// encoder if there is one, and returns how many results were written.
+func (p *process[In, Out]) encodeBatch(batch TypedBatchEncoder[Out], rs []result[Out]) (int, error) {
+	values := make([]Out, 0, len(rs))
+	n := 0
+	for _, r := range rs {
+		switch {
+		case r.skip:
+		case r.err == nil:
+			values = append(values, r.value)
+		case p.config.DeadLetter != nil:
+			if err := p.config.DeadLetter.Encode(r.err); err != nil {
+				return n, err
+			}
+			n++
+		default:
+			if v, ok := interface{}(r.err).(Out); ok {
+				values = append(values, v)
+			}
+		}
+	}
+	if len(values) == 0 {
+		return n, nil
+	}
+	if err := batch.EncodeBatch(values); err != nil {
+		return n, err
+	}
+	return n + len(values), nil
+}
+
+// closeBatch flushes and closes a batch encoder at the end of a run.
+func (p *process[In, Out]) closeBatch(batch TypedBatchEncoder[Out]) {
+	if err := batch.Flush(); err != nil {
+		p.fail(err)
+	}
+	if err := batch.Close(); err != nil {
+		p.fail(err)
+	}
+}
+
+// flushOutput flushes out at the end of a run if it buffers output.
+func (p *process[In, Out]) flushOutput(out interface{}) {
+	if f, ok := out.(flusher); ok {
+		if err := f.Flush(); err != nil {
+			p.fail(err)
+		}
+	}
+}
+
+// checkpoint flushes the output and persists the number of input records
+// whose results have been encoded.
+func (p *process[In, Out]) checkpoint(records uint64) {
+	cc := p.config.Checkpoint
+	for _, out := range []interface{}{p.out, p.config.DeadLetter} {
+		if f, ok := out.(flusher); ok {
+			if err := f.Flush(); err != nil {
+				p.fail(err)
+				return
+			}
+		}
+	}
+	cp := &Checkpoint{Records: records, Offset: -1, Time: time.Now()}
+	if cc.Sync != nil {
+		offset, err := cc.Sync()
+		if err != nil {
+			p.fail(err)
+			return
+		}
+		cp.Offset = offset
+	}
+	if err := cp.Save(cc.Path); err != nil {
+		p.fail(err)
+	}
+}
@@ -0,0 +1,151 @@
+package processing
+
+import (
+	"encoding/json"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/zmap/ztools/zlog"
+)
+
+// DefaultProgressInterval is used when ProgressConfig.Interval is zero.
+const DefaultProgressInterval = 10 * time.Second
+
+// ProgressConfig configures periodic progress reports for ProcessConfig.
+// Reports read the Worker's Success, Failure and Total counters from a
+// separate goroutine, so they must be safe for concurrent use.
+type ProgressConfig struct {
+	// Interval is the time between reports. A final report is always
+	// emitted when the run ends.
+	Interval time.Duration
+	// Logger, if set, receives each report as an INFO line.
+	Logger *zlog.Logger
+	// Writer, if set, receives each report as a line of JSON.
+	Writer io.Writer
+	// Size is the total size of the input in bytes and Position reports how
+	// much of it has been consumed. When both are set, reports include the
+	// fraction done and an ETA. A CountingReader provides Position.
+	Size     int64
+	Position func() int64
+}
+
+func (pc *ProgressConfig) interval() time.Duration {
+	if pc.Interval <= 0 {
+		return DefaultProgressInterval
+	}
+	return pc.Interval
+}
+
+// ProgressStatus is a single progress report.
+type ProgressStatus struct {
+	Time         time.Time `json:"time"`
+	Elapsed      float64   `json:"elapsed"`
+	Read         uint64    `json:"read"`
+	Written      uint64    `json:"written"`
+	Rate         float64   `json:"rate"`
+	Success      uint      `json:"success"`
+	Failure      uint      `json:"failure"`
+	Total        uint      `json:"total"`
+	SuccessRatio float64   `json:"success_ratio"`
+	ProcessQueue int       `json:"process_queue"`
+	OutputQueue  int       `json:"output_queue"`
+	Workers      int64     `json:"workers"`
+	Done         float64   `json:"done,omitempty"`
+	ETA          float64   `json:"eta,omitempty"`
+}
+
+// CountingReader counts the bytes read through it.
+type CountingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func NewCountingReader(r io.Reader) *CountingReader {
+	return &CountingReader{r: r}
+}
+
+func (c *CountingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// Position returns the number of bytes read so far.
+func (c *CountingReader) Position() int64 {
+	return c.n.Load()
+}
+
+// reportProgress emits a report every interval until stop is closed, then
+// emits a final one.
+func (p *process[In, Out]) reportProgress(stop <-chan struct{}) {
+	pc := p.config.Progress
+	ticker := time.NewTicker(pc.interval())
+	defer ticker.Stop()
+	began := time.Now()
+	last, lastWritten := began, uint64(0)
+	for {
+		final := false
+		select {
+		case <-ticker.C:
+		case <-stop:
+			final = true
+		}
+		now := time.Now()
+		status := p.progress(began, now)
+		if dt := now.Sub(last).Seconds(); dt > 0 {
+			status.Rate = float64(status.Written-lastWritten) / dt
+		}
+		last, lastWritten = now, status.Written
+		p.emitProgress(status)
+		if final {
+			return
+		}
+	}
+}
+
+func (p *process[In, Out]) progress(began, now time.Time) *ProgressStatus {
+	pc := p.config.Progress
+	status := &ProgressStatus{
+		Time:         now,
+		Elapsed:      now.Sub(began).Seconds(),
+		Read:         p.read.Load(),
+		Written:      p.written.Load(),
+		Success:      p.w.Success(),
+		Failure:      p.w.Failure(),
+		Total:        p.w.Total(),
+		ProcessQueue: len(p.processQueue),
+		OutputQueue:  len(p.outputQueue),
+		Workers:      p.active.Load(),
+	}
+	if status.Total > 0 {
+		status.SuccessRatio = float64(status.Success) / float64(status.Total)
+	}
+	if pc.Size > 0 && pc.Position != nil {
+		status.Done = float64(pc.Position()) / float64(pc.Size)
+		if status.Done > 0 && status.Done < 1 {
+			status.ETA = status.Elapsed * (1 - status.Done) / status.Done
+		}
+	}
+	return status
+}
+
+func (p *process[In, Out]) emitProgress(status *ProgressStatus) {
+	pc := p.config.Progress
+	if pc.Logger != nil {
+		eta := "unknown"
+		if status.ETA > 0 {
+			eta = time.Duration(status.ETA * float64(time.Second)).Round(time.Second).String()
+		}
+		pc.Logger.Infof("read %d, written %d (%.1f/s), success %.1f%%, queued %d/%d, ETA %s",
+			status.Read, status.Written, status.Rate, 100*status.SuccessRatio,
+			status.ProcessQueue, status.OutputQueue, eta)
+	}
+	if pc.Writer != nil {
+		b, err := json.Marshal(status)
+		if err != nil {
+			return
+		}
+		pc.Writer.Write(append(b, '\n'))
+	}
+}
@@ -0,0 +1,50 @@
+package processing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type ProgressSuite struct{}
+
+var _ = Suite(&ProgressSuite{})
+
+func (s *ProgressSuite) TestCountingReader(c *C) {
+	r := NewCountingReader(strings.NewReader("hello world"))
+	buf := make([]byte, 5)
+	r.Read(buf)
+	c.Check(r.Position(), Equals, int64(5))
+}
+
+func (s *ProgressSuite) TestReports(c *C) {
+	var status bytes.Buffer
+	payload := strings.Repeat("{\"ip\":\"1.2.3.4\"}\n", 200)
+	input := NewCountingReader(strings.NewReader(payload))
+	config := &Config{
+		Progress: &ProgressConfig{
+			Interval: time.Millisecond,
+			Writer:   &status,
+			Size:     int64(len(payload)),
+			Position: input.Position,
+		},
+	}
+	w := &testWorker{handler: func(v interface{}) interface{} {
+		time.Sleep(50 * time.Microsecond)
+		return v
+	}}
+	err := ProcessConfig(context.Background(), NewJSONLinesDecoder(input), new(sliceEncoder), w, 2, config)
+	c.Assert(err, IsNil)
+
+	lines := strings.Split(strings.TrimSpace(status.String()), "\n")
+	c.Assert(len(lines) >= 1, Equals, true)
+	var final ProgressStatus
+	c.Assert(json.Unmarshal([]byte(lines[len(lines)-1]), &final), IsNil)
+	c.Check(final.Read, Equals, uint64(200))
+	c.Check(final.Written, Equals, uint64(200))
+	c.Check(final.Done, Equals, 1.0)
+}
@@ -0,0 +1,237 @@
+package processing
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ArrayMode selects how a Projection renders a field with several values,
+// such as a path through an array.
+type ArrayMode int
+
+const (
+	// ArrayJoin joins the values into a single field with the projection's
+	// separator.
+	ArrayJoin ArrayMode = iota
+	// ArrayFirst keeps only the first value.
+	ArrayFirst
+	// ArrayExplode emits one row per value. A record with several such
+	// fields yields a row for every combination of their values.
+	ArrayExplode
+)
+
+func (m ArrayMode) String() string {
+	switch m {
+	case ArrayJoin:
+		return "join"
+	case ArrayFirst:
+		return "first"
+	case ArrayExplode:
+		return "explode"
+	}
+	return "unknown"
+}
+
+// ParseArrayMode returns the ArrayMode named by s, one of "join", "first"
+// and "explode".
+func ParseArrayMode(s string) (ArrayMode, error) {
+	for _, m := range []ArrayMode{ArrayJoin, ArrayFirst, ArrayExplode} {
+		if m.String() == s {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("processing: unknown array mode %q", s)
+}
+
+// Column is a field of the rows produced by a Projection. Byte fields,
+// such as server_hello.random, are base64 strings in JSON, and are rendered
+// as such unless Hex is set.
+type Column struct {
+	Name string
+	Path string
+	Hex  bool
+}
+
+// ParseColumn parses a column given as "[name=]path[:encoding]", such as
+// "random=server_hello.random:hex". The name defaults to the path, and the
+// encoding of byte fields is "base64" or "hex".
+func ParseColumn(spec string) (Column, error) {
+	var col Column
+	if i := strings.IndexByte(spec, '='); i >= 0 {
+		col.Name, spec = spec[:i], spec[i+1:]
+	}
+	if i := strings.LastIndexByte(spec, ':'); i >= 0 {
+		switch spec[i+1:] {
+		case "hex":
+			col.Hex = true
+		case "base64":
+		default:
+			return Column{}, fmt.Errorf("processing: unknown encoding %q in column %q", spec[i+1:], spec)
+		}
+		spec = spec[:i]
+	}
+	if spec == "" {
+		return Column{}, fmt.Errorf("processing: column without a field path")
+	}
+	col.Path = spec
+	if col.Name == "" {
+		col.Name = col.Path
+	}
+	return col, nil
+}
+
+// Projection flattens records into rows of strings, one field per column,
+// for output as CSV or TSV:
+//
+//	p, err := NewProjection([]string{"ip", "server_hello.cipher_suite",
+//		"server_certificates.common_name"}, ArrayJoin)
+//	out := NewCSVEncoder(os.Stdout, p.Header())
+//	err = ProcessConfig(ctx, in, out, NewProjectionWorker(w, p), workers, nil)
+//
+// Numbers are rendered in decimal, missing fields and nulls as empty
+// fields, and objects as JSON.
+type Projection struct {
+	Columns []Column
+	Arrays  ArrayMode
+	// Separator joins the values of a field in ArrayJoin mode. It defaults
+	// to "|".
+	Separator string
+}
+
+// NewProjection returns a Projection with the columns given by specs, in
+// the format accepted by ParseColumn.
+func NewProjection(specs []string, arrays ArrayMode) (*Projection, error) {
+	p := &Projection{Arrays: arrays}
+	for _, spec := range specs {
+		col, err := ParseColumn(spec)
+		if err != nil {
+			return nil, err
+		}
+		p.Columns = append(p.Columns, col)
+	}
+	return p, nil
+}
+
+// Header returns the column names.
+func (p *Projection) Header() []string {
+	header := make([]string, len(p.Columns))
+	for i, col := range p.Columns {
+		header[i] = col.Name
+	}
+	return header
+}
+
+func (p *Projection) separator() string {
+	if p.Separator == "" {
+		return "|"
+	}
+	return p.Separator
+}
+
+// Rows returns the rows of record v: exactly one, unless Arrays is
+// ArrayExplode.
+func (p *Projection) Rows(v interface{}) ([][]string, error) {
+	n, err := normalize(v)
+	if err != nil {
+		return nil, err
+	}
+	fields := make([][]string, len(p.Columns))
+	for i, col := range p.Columns {
+		values := lookup(n, splitPath(col.Path))
+		rendered := make([]string, len(values))
+		for j, value := range values {
+			if rendered[j], err = renderField(value, col.Hex); err != nil {
+				return nil, err
+			}
+		}
+		fields[i] = rendered
+	}
+	if p.Arrays == ArrayExplode {
+		return explode(fields), nil
+	}
+	row := make([]string, len(fields))
+	for i, values := range fields {
+		switch {
+		case len(values) == 0:
+		case p.Arrays == ArrayFirst:
+			row[i] = values[0]
+		default:
+			row[i] = strings.Join(values, p.separator())
+		}
+	}
+	return [][]string{row}, nil
+}
+
+// explode returns a row for every combination of the values of fields. A
+// field without values is empty in every row.
+func explode(fields [][]string) [][]string {
+	rows := [][]string{make([]string, 0, len(fields))}
+	for _, values := range fields {
+		if len(values) == 0 {
+			values = []string{""}
+		}
+		next := make([][]string, 0, len(rows)*len(values))
+		for _, row := range rows {
+			for _, value := range values {
+				r := make([]string, len(row), len(fields))
+				copy(r, row)
+				next = append(next, append(r, value))
+			}
+		}
+		rows = next
+	}
+	return rows
+}
+
+func renderField(v interface{}, asHex bool) (string, error) {
+	switch x := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		if asHex {
+			b, err := base64.StdEncoding.DecodeString(x)
+			if err != nil {
+				return "", fmt.Errorf("processing: field is not base64 encoded bytes: %v", err)
+			}
+			return hex.EncodeToString(b), nil
+		}
+		return x, nil
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(x), nil
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// ProjectionWorker wraps a Worker so that the results of its handlers are
+// replaced by their rows under a Projection, as a [][]string for a
+// CSVEncoder. Failed and skipped records are passed through unchanged.
+type ProjectionWorker struct {
+	wrappedWorker
+}
+
+func NewProjectionWorker(w Worker, p *Projection) *ProjectionWorker {
+	return &ProjectionWorker{
+		wrappedWorker: wrapWorker(w, mapResults(func(v interface{}) interface{} {
+			rows, err := p.Rows(v)
+			if err != nil {
+				return Fail(err)
+			}
+			return rows
+		})),
+	}
+}
+
+// Project starts a flow stage that replaces each record with its rows under
+// p.
+func Project[T any](s *Stream[T], opts StageOptions, p *Projection) *Stream[[]string] {
+	return FlatMap(s, opts, func(v T) ([][]string, error) {
+		return p.Rows(v)
+	})
+}
@@ -0,0 +1,94 @@
+package processing
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type ProjectSuite struct{}
+
+var _ = Suite(&ProjectSuite{})
+
+const projectRecord = `{"ip":"1.2.3.4","server_hello":{"version":771,"cipher_suite":49199,"random":"3q2+7w==","session_id":null},"server_certificates":{"common_name":"example.com","alt_names":["a.example.com","b.example.com"]},"extra":{"x":1}}`
+
+func (s *ProjectSuite) TestParseColumn(c *C) {
+	col, err := ParseColumn("random=server_hello.random:hex")
+	c.Assert(err, IsNil)
+	c.Check(col, Equals, Column{Name: "random", Path: "server_hello.random", Hex: true})
+	col, err = ParseColumn("server_hello.session_id:base64")
+	c.Assert(err, IsNil)
+	c.Check(col, Equals, Column{Name: "server_hello.session_id", Path: "server_hello.session_id"})
+	_, err = ParseColumn("server_hello.random:octal")
+	c.Check(err, NotNil)
+	_, err = ParseColumn("name=")
+	c.Check(err, NotNil)
+}
+
+func (s *ProjectSuite) TestRows(c *C) {
+	specs := []string{"ip", "server_hello.cipher_suite", "server_hello.random:hex", "server_hello.random",
+		"server_hello.session_id", "server_certificates.alt_names", "extra", "missing"}
+	for mode, want := range map[ArrayMode][][]string{
+		ArrayJoin: {
+			{"1.2.3.4", "49199", "deadbeef", "3q2+7w==", "", "a.example.com|b.example.com", `{"x":1}`, ""},
+		},
+		ArrayFirst: {
+			{"1.2.3.4", "49199", "deadbeef", "3q2+7w==", "", "a.example.com", `{"x":1}`, ""},
+		},
+		ArrayExplode: {
+			{"1.2.3.4", "49199", "deadbeef", "3q2+7w==", "", "a.example.com", `{"x":1}`, ""},
+			{"1.2.3.4", "49199", "deadbeef", "3q2+7w==", "", "b.example.com", `{"x":1}`, ""},
+		},
+	} {
+		p, err := NewProjection(specs, mode)
+		c.Assert(err, IsNil)
+		rows, err := p.Rows(map[string]interface{}{})
+		c.Assert(err, IsNil)
+		c.Check(rows, HasLen, 1)
+		record, err := NewJSONLinesDecoder(strings.NewReader(projectRecord)).DecodeNext()
+		c.Assert(err, IsNil)
+		rows, err = p.Rows(record)
+		c.Assert(err, IsNil)
+		c.Check(rows, DeepEquals, want, Commentf("mode %v", mode))
+	}
+}
+
+func (s *ProjectSuite) TestExplodeCombinations(c *C) {
+	p, err := NewProjection([]string{"a", "b", "c"}, ArrayExplode)
+	c.Assert(err, IsNil)
+	rows, err := p.Rows(map[string]interface{}{
+		"a": []interface{}{"1", "2"},
+		"b": []interface{}{"x", "y"},
+	})
+	c.Assert(err, IsNil)
+	c.Check(rows, DeepEquals, [][]string{{"1", "x", ""}, {"1", "y", ""}, {"2", "x", ""}, {"2", "y", ""}})
+}
+
+func (s *ProjectSuite) TestProjectionWorker(c *C) {
+	in := "{\"ip\":\"1.2.3.4\",\"random\":\"AAE=\"}\n{\"ip\":\"5.6.7.8\",\"random\":\"not base64\"}\n{\"ip\":\"9.9.9.9\"}\n"
+	p, err := NewProjection([]string{"ip", "random:hex"}, ArrayJoin)
+	c.Assert(err, IsNil)
+	var out bytes.Buffer
+	dead := new(sliceEncoder)
+	err = ProcessConfig(context.Background(), NewJSONLinesDecoder(strings.NewReader(in)),
+		NewCSVEncoder(&out, p.Header()), NewProjectionWorker(new(testWorker), p), 2,
+		&Config{Ordered: true, DeadLetter: dead})
+	c.Assert(err, IsNil)
+	c.Check(out.String(), Equals, "ip,random\n1.2.3.4,0001\n9.9.9.9,\n")
+	c.Check(dead.values, HasLen, 1)
+}
+
+func (s *ProjectSuite) TestProjectStage(c *C) {
+	p, err := NewProjection([]string{"ip", "port"}, ArrayJoin)
+	c.Assert(err, IsNil)
+	var out bytes.Buffer
+	enc := NewTSVEncoder(&out, p.Header())
+	f := NewFlow(context.Background(), nil)
+	hosts := Source[*host](f, NewTypedJSONLinesDecoder[host](strings.NewReader("{\"ip\":\"1.2.3.4\",\"port\":443}\n")), 0)
+	Sink(Project(hosts, StageOptions{}, p), NewTypedEncoder[[]string](enc))
+	c.Assert(f.Wait(), IsNil)
+	c.Assert(enc.Close(), IsNil)
+	c.Check(out.String(), Equals, "ip\tport\n1.2.3.4\t443\n")
+}
@@ -0,0 +1,192 @@
+package processing
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// Limiter paces the records handed to handlers. Wait blocks until v may be
+// handled, or returns ctx's error once ctx is done.
+type Limiter interface {
+	Wait(ctx context.Context, v interface{}) error
+}
+
+// bucket is a token bucket holding up to burst tokens, refilled at rate
+// tokens per second. Its token count goes negative when callers reserve
+// tokens ahead of time.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) refill(now time.Time, rate, burst float64) {
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+}
+
+// reserve takes a token and returns how long the caller must wait before
+// using it.
+func (b *bucket) reserve(now time.Time, rate, burst float64) time.Duration {
+	b.refill(now, rate, burst)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RateLimiter is a Limiter that allows rate records per second on average,
+// and bursts of up to burst records. A single RateLimiter shared by every
+// worker, or used directly by handlers, enforces an aggregate budget.
+type RateLimiter struct {
+	mu    sync.Mutex
+	rate  float64
+	burst float64
+	b     bucket
+}
+
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:  rate,
+		burst: float64(burst),
+		b:     bucket{tokens: float64(burst), last: time.Now()},
+	}
+}
+
+func (l *RateLimiter) Wait(ctx context.Context, v interface{}) error {
+	l.mu.Lock()
+	delay := l.b.reserve(time.Now(), l.rate, l.burst)
+	l.mu.Unlock()
+	if err := sleep(ctx, delay); err != nil {
+		l.mu.Lock()
+		l.b.tokens++
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// KeyedRateLimiter is a Limiter that keeps a separate token bucket for each
+// key, such as the /24 subnet of a record's address, so that no single key
+// exceeds the rate. Buckets that have refilled completely are forgotten.
+type KeyedRateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	key     func(interface{}) string
+	buckets map[string]*bucket
+	// sweepAt is the bucket count that triggers the next sweep of idle
+	// buckets.
+	sweepAt int
+}
+
+func NewKeyedRateLimiter(rate float64, burst int, key func(interface{}) string) *KeyedRateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &KeyedRateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		key:     key,
+		buckets: make(map[string]*bucket),
+		sweepAt: 1024,
+	}
+}
+
+func (l *KeyedRateLimiter) Wait(ctx context.Context, v interface{}) error {
+	key := l.key(v)
+	now := time.Now()
+	l.mu.Lock()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= l.sweepAt {
+			l.sweep(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	delay := b.reserve(now, l.rate, l.burst)
+	l.mu.Unlock()
+	if err := sleep(ctx, delay); err != nil {
+		l.mu.Lock()
+		b.tokens++
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// sweep forgets buckets that are full again, since a fresh bucket behaves
+// identically. It must be called with l.mu held.
+func (l *KeyedRateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		b.refill(now, l.rate, l.burst)
+		if b.tokens >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.sweepAt = 2 * len(l.buckets)
+	if l.sweepAt < 1024 {
+		l.sweepAt = 1024
+	}
+}
+
+type limiters []Limiter
+
+func (ls limiters) Wait(ctx context.Context, v interface{}) error {
+	for _, l := range ls {
+		if err := l.Wait(ctx, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Limiters combines limiters so that a record waits for each of them in
+// turn, such as an aggregate RateLimiter and a per-subnet KeyedRateLimiter.
+func Limiters(ls ...Limiter) Limiter {
+	return limiters(ls)
+}
+
+// SubnetKey returns the network of the given bit length containing the
+// address ip, using v4Bits for IPv4 and v6Bits for IPv6 addresses. It
+// returns ip unchanged if it is not a valid address.
+func SubnetKey(ip string, v4Bits, v6Bits int) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap()
+	bits := v6Bits
+	if addr.Is4() {
+		bits = v4Bits
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ip
+	}
+	return prefix.String()
+}
@@ -0,0 +1,58 @@
+package processing
+
+import (
+	"context"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type RateLimitSuite struct{}
+
+var _ = Suite(&RateLimitSuite{})
+
+func (s *RateLimitSuite) TestRateLimiter(c *C) {
+	l := NewRateLimiter(1000, 10)
+	start := time.Now()
+	for i := 0; i < 60; i++ {
+		c.Assert(l.Wait(context.Background(), nil), IsNil)
+	}
+	c.Check(time.Since(start) >= 45*time.Millisecond, Equals, true)
+}
+
+func (s *RateLimitSuite) TestRateLimiterCancel(c *C) {
+	l := NewRateLimiter(1, 1)
+	c.Assert(l.Wait(context.Background(), nil), IsNil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	c.Check(l.Wait(ctx, nil), Equals, context.DeadlineExceeded)
+}
+
+func (s *RateLimitSuite) TestKeyedRateLimiter(c *C) {
+	key := func(v interface{}) string {
+		return SubnetKey(v.(string), 24, 48)
+	}
+	l := NewKeyedRateLimiter(1, 1, key)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	c.Assert(l.Wait(ctx, "10.0.0.1"), IsNil)
+	c.Assert(l.Wait(ctx, "10.0.1.1"), IsNil)
+	c.Check(l.Wait(ctx, "10.0.0.2"), Equals, context.DeadlineExceeded)
+}
+
+func (s *RateLimitSuite) TestSubnetKey(c *C) {
+	c.Check(SubnetKey("192.168.1.77", 24, 48), Equals, "192.168.1.0/24")
+	c.Check(SubnetKey("::ffff:192.168.1.77", 24, 48), Equals, "192.168.1.0/24")
+	c.Check(SubnetKey("2001:db8:1:2::1", 24, 48), Equals, "2001:db8:1::/48")
+	c.Check(SubnetKey("not an ip", 24, 48), Equals, "not an ip")
+}
+
+func (s *RateLimitSuite) TestProcessRateLimit(c *C) {
+	config := &Config{RateLimit: NewRateLimiter(2000, 1)}
+	start := time.Now()
+	out := new(sliceEncoder)
+	err := ProcessConfig(context.Background(), &sliceDecoder{values: intRange(41)}, out, new(testWorker), 8, config)
+	c.Assert(err, IsNil)
+	c.Check(out.values, HasLen, 41)
+	c.Check(time.Since(start) >= 18*time.Millisecond, Equals, true)
+}
@@ -0,0 +1,119 @@
+package processing
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+)
+
+var (
+	ErrInvalidShard = errors.New("processing: shard index must be less than shard count")
+)
+
+// ShardDecoder yields the subset of another Decoder's records that belong to
+// one of count shards, so that the same job can run on several hosts over
+// the same input. Without a key function, record n belongs to shard
+// n % count. With one, a record belongs to the shard selected by a hash of
+// its key, which keeps records with equal keys on the same shard.
+type ShardDecoder struct {
+	in    Decoder
+	index uint64
+	count uint64
+	key   func(interface{}) []byte
+	n     uint64
+}
+
+func NewShardDecoder(in Decoder, index, count uint, key func(interface{}) []byte) (*ShardDecoder, error) {
+	if index >= count {
+		return nil, ErrInvalidShard
+	}
+	return &ShardDecoder{
+		in:    in,
+		index: uint64(index),
+		count: uint64(count),
+		key:   key,
+	}, nil
+}
+
+// ParseShard parses a shard given as "index/count", such as "0/4".
+func ParseShard(s string) (index, count uint, err error) {
+	if _, err = fmt.Sscanf(s, "%d/%d", &index, &count); err != nil {
+		return 0, 0, fmt.Errorf("processing: invalid shard %q: %v", s, err)
+	}
+	if index >= count {
+		return 0, 0, ErrInvalidShard
+	}
+	return index, count, nil
+}
+
+func (s *ShardDecoder) shard(v interface{}) uint64 {
+	n := s.n
+	s.n++
+	if s.key == nil {
+		return n % s.count
+	}
+	h := fnv.New64a()
+	h.Write(s.key(v))
+	return h.Sum64() % s.count
+}
+
+func (s *ShardDecoder) DecodeNext() (interface{}, error) {
+	for {
+		v, err := s.in.DecodeNext()
+		var malformed *MalformedRecordError
+		if errors.As(err, &malformed) && s.key == nil {
+			// A malformed record still occupies its position, and is
+			// reported by the shard that position belongs to.
+			if s.shard(nil) == s.index {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if s.shard(v) == s.index {
+			return v, nil
+		}
+	}
+}
+
+// ShardMerger reassembles the outputs of a sharded run. Concatenating
+// merges shard after shard. Interleaving takes one record from each shard in
+// turn, which restores the input order of a run sharded by record number
+// when each shard was processed in order and produced exactly one result
+// per record.
+type ShardMerger struct {
+	shards     []Decoder
+	interleave bool
+	next       int
+}
+
+func NewShardMerger(interleave bool, shards ...Decoder) *ShardMerger {
+	return &ShardMerger{
+		shards:     shards,
+		interleave: interleave,
+	}
+}
+
+func (m *ShardMerger) DecodeNext() (interface{}, error) {
+	for len(m.shards) > 0 {
+		if m.next >= len(m.shards) {
+			m.next = 0
+		}
+		v, err := m.shards[m.next].DecodeNext()
+		if err == io.EOF {
+			m.shards = append(m.shards[:m.next], m.shards[m.next+1:]...)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if m.interleave {
+			m.next++
+		}
+		return v, nil
+	}
+	return nil, io.EOF
+}
@@ -0,0 +1,73 @@
+package processing
+
+import (
+	"io"
+	"strconv"
+
+	. "gopkg.in/check.v1"
+)
+
+type ShardSuite struct{}
+
+var _ = Suite(&ShardSuite{})
+
+func drain(c *C, d Decoder) []interface{} {
+	var values []interface{}
+	for {
+		v, err := d.DecodeNext()
+		if err == io.EOF {
+			return values
+		}
+		c.Assert(err, IsNil)
+		values = append(values, v)
+	}
+}
+
+func (s *ShardSuite) TestParseShard(c *C) {
+	index, count, err := ParseShard("2/8")
+	c.Assert(err, IsNil)
+	c.Check(index, Equals, uint(2))
+	c.Check(count, Equals, uint(8))
+	_, _, err = ParseShard("8/8")
+	c.Check(err, Equals, ErrInvalidShard)
+	_, _, err = ParseShard("two")
+	c.Check(err, NotNil)
+}
+
+func (s *ShardSuite) TestLineShardsInterleave(c *C) {
+	var shards []Decoder
+	for i := uint(0); i < 3; i++ {
+		d, err := NewShardDecoder(&sliceDecoder{values: intRange(10)}, i, 3, nil)
+		c.Assert(err, IsNil)
+		shards = append(shards, &sliceDecoder{values: drain(c, d)})
+	}
+	c.Check(shards[1].(*sliceDecoder).values, DeepEquals, []interface{}{1, 4, 7})
+	c.Check(drain(c, NewShardMerger(true, shards...)), DeepEquals, intRange(10))
+}
+
+func (s *ShardSuite) TestKeyShards(c *C) {
+	values := []interface{}{1, 2, 3, 1, 2, 3, 4, 5, 6}
+	key := func(v interface{}) []byte {
+		return []byte(strconv.Itoa(v.(int)))
+	}
+	var shards []Decoder
+	seen := make(map[interface{}]uint)
+	for i := uint(0); i < 2; i++ {
+		d, err := NewShardDecoder(&sliceDecoder{values: values}, i, 2, key)
+		c.Assert(err, IsNil)
+		got := drain(c, d)
+		for _, v := range got {
+			if shard, ok := seen[v]; ok {
+				c.Check(shard, Equals, i)
+			}
+			seen[v] = i
+		}
+		shards = append(shards, &sliceDecoder{values: got})
+	}
+	c.Check(drain(c, NewShardMerger(false, shards...)), HasLen, len(values))
+}
+
+func (s *ShardSuite) TestInvalidShard(c *C) {
+	_, err := NewShardDecoder(new(sliceDecoder), 3, 3, nil)
+	c.Check(err, Equals, ErrInvalidShard)
+}
@@ -0,0 +1,266 @@
+package processing
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+var (
+	ErrNoRoute = errors.New("processing: no sink for route")
+)
+
+// distinct returns encoders without repeats, so that an encoder used for
+// several routes, or also as the default, is flushed and closed once.
+// Encoders that cannot be compared, such as a Tee, are kept as they are.
+func distinct(encoders []Encoder) []Encoder {
+	seen := make(map[Encoder]bool, len(encoders))
+	unique := make([]Encoder, 0, len(encoders))
+	for _, e := range encoders {
+		if e != nil && reflect.TypeOf(e).Comparable() {
+			if seen[e] {
+				continue
+			}
+			seen[e] = true
+		}
+		unique = append(unique, e)
+	}
+	return unique
+}
+
+// flushAll flushes every distinct encoder that buffers output.
+func flushAll(encoders []Encoder) error {
+	var err error
+	for _, e := range distinct(encoders) {
+		if f, ok := e.(flusher); ok {
+			if ferr := f.Flush(); ferr != nil && err == nil {
+				err = ferr
+			}
+		}
+	}
+	return err
+}
+
+// encodeBatch writes vs to e, in a single batch if e is a BatchEncoder.
+func encodeBatch(e Encoder, vs []interface{}) error {
+	if b, ok := e.(BatchEncoder); ok {
+		return b.EncodeBatch(vs)
+	}
+	for _, v := range vs {
+		if err := e.Encode(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeAll closes every distinct encoder that can be closed.
+func closeAll(encoders []Encoder) error {
+	var err error
+	for _, e := range distinct(encoders) {
+		if c, ok := e.(io.Closer); ok {
+			if cerr := c.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
+
+// Router is an Encoder that writes each value to the sink named by its
+// route function, such as "valid", "tls_error" or "heartbleed". Values with
+// a route that has no sink go to Default, or fail with ErrNoRoute if Default
+// is nil. EncodeBatch passes each sink its share of the batch, Flush and
+// Close apply once to every sink, even one used for several routes, so that a Router used as the output of Process
+// is batched, flushed and closed like a single encoder. Like the output of
+// Process, a Router is not safe for concurrent use.
+type Router struct {
+	Default Encoder
+
+	route func(interface{}) string
+	sinks map[string]Encoder
+}
+
+func NewRouter(route func(interface{}) string, sinks map[string]Encoder) *Router {
+	return &Router{
+		route: route,
+		sinks: sinks,
+	}
+}
+
+func (r *Router) Encode(v interface{}) error {
+	name := r.route(v)
+	e, ok := r.sinks[name]
+	if !ok {
+		if r.Default == nil {
+			return fmt.Errorf("%w %q", ErrNoRoute, name)
+		}
+		e = r.Default
+	}
+	return e.Encode(v)
+}
+
+// EncodeBatch writes vs to their sinks, in order within each sink. If a
+// value has no sink, nothing is written.
+func (r *Router) EncodeBatch(vs []interface{}) error {
+	var sinks []Encoder
+	var batches [][]interface{}
+	index := make(map[string]int)
+	for _, v := range vs {
+		name := r.route(v)
+		i, ok := index[name]
+		if !ok {
+			e, ok := r.sinks[name]
+			if !ok {
+				if r.Default == nil {
+					return fmt.Errorf("%w %q", ErrNoRoute, name)
+				}
+				e = r.Default
+			}
+			i = len(sinks)
+			index[name] = i
+			sinks = append(sinks, e)
+			batches = append(batches, nil)
+		}
+		batches[i] = append(batches[i], v)
+	}
+	for i, e := range sinks {
+		if err := encodeBatch(e, batches[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Router) encoders() []Encoder {
+	encoders := make([]Encoder, 0, len(r.sinks)+1)
+	for _, e := range r.sinks {
+		encoders = append(encoders, e)
+	}
+	if r.Default != nil {
+		encoders = append(encoders, r.Default)
+	}
+	return encoders
+}
+
+func (r *Router) Flush() error {
+	return flushAll(r.encoders())
+}
+
+func (r *Router) Close() error {
+	return closeAll(r.encoders())
+}
+
+// Tee is an Encoder that writes every value to each of its encoders in
+// turn, such as a full JSON file and a summary CSV. EncodeBatch, Flush and
+// Close apply to every encoder; Flush and Close only once to an encoder that
+// appears several times.
+type Tee []Encoder
+
+func NewTee(encoders ...Encoder) Tee {
+	return Tee(encoders)
+}
+
+func (t Tee) Encode(v interface{}) error {
+	for _, e := range t {
+		if err := e.Encode(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t Tee) EncodeBatch(vs []interface{}) error {
+	for _, e := range t {
+		if err := encodeBatch(e, vs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t Tee) Flush() error {
+	return flushAll(t)
+}
+
+func (t Tee) Close() error {
+	return closeAll(t)
+}
+
+// FileEncoder is a BatchEncoder that writes to a buffered file opened by
+// CreateOutput. Close flushes and closes the file.
+type FileEncoder struct {
+	Encoder
+	buf *bufio.Writer
+	w   io.WriteCloser
+}
+
+// CreateJSONLinesFile creates the named file, compressed according to its
+// extension, and returns an encoder writing JSON lines to it.
+func CreateJSONLinesFile(name string) (*FileEncoder, error) {
+	w, err := CreateOutput(name)
+	if err != nil {
+		return nil, err
+	}
+	return newFileEncoder(w), nil
+}
+
+// ResumeJSONLinesFile is the counterpart of CreateJSONLinesFile for a run
+// resumed from cp: it opens the named file with ResumeOutput and appends to
+// it. Only uncompressed and gzip files can be resumed, since a gzip file cut
+// at a checkpoint ends with a complete member; other formats fail with
+// ErrUnsupportedCompression.
+func ResumeJSONLinesFile(name string, cp *Checkpoint) (*FileEncoder, error) {
+	c := CompressionForName(name)
+	if c != CompressionNone && c != CompressionGzip {
+		return nil, ErrUnsupportedCompression
+	}
+	f, err := ResumeOutput(name, cp)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewCompressWriter(f, c)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return newFileEncoder(&compressedFile{WriteCloser: w, f: f}), nil
+}
+
+func newFileEncoder(w io.WriteCloser) *FileEncoder {
+	buf := bufio.NewWriterSize(w, 256*1024)
+	return &FileEncoder{
+		Encoder: NewJSONLinesEncoder(buf),
+		buf:     buf,
+		w:       w,
+	}
+}
+
+func (f *FileEncoder) EncodeBatch(vs []interface{}) error {
+	for _, v := range vs {
+		if err := f.Encode(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FileEncoder) Flush() error {
+	if err := f.buf.Flush(); err != nil {
+		return err
+	}
+	if fl, ok := f.w.(flusher); ok {
+		return fl.Flush()
+	}
+	return nil
+}
+
+func (f *FileEncoder) Close() error {
+	err := f.buf.Flush()
+	if cerr := f.w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,182 @@
+package processing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type SinkSuite struct{}
+
+var _ = Suite(&SinkSuite{})
+
+type closingEncoder struct {
+	sliceEncoder
+	flushed, closed bool
+}
+
+func (e *closingEncoder) Flush() error {
+	e.flushed = true
+	return nil
+}
+
+func (e *closingEncoder) Close() error {
+	e.closed = true
+	return nil
+}
+
+func parity(v interface{}) string {
+	if v.(int)%2 == 0 {
+		return "even"
+	}
+	return "odd"
+}
+
+func (s *SinkSuite) TestRouter(c *C) {
+	even, odd := new(closingEncoder), new(closingEncoder)
+	r := NewRouter(parity, map[string]Encoder{"even": even, "odd": odd})
+	for _, v := range intRange(5) {
+		c.Assert(r.Encode(v), IsNil)
+	}
+	c.Check(even.values, DeepEquals, []interface{}{0, 2, 4})
+	c.Check(odd.values, DeepEquals, []interface{}{1, 3})
+	c.Assert(r.Flush(), IsNil)
+	c.Assert(r.Close(), IsNil)
+	c.Check(even.flushed && odd.flushed, Equals, true)
+	c.Check(even.closed && odd.closed, Equals, true)
+}
+
+func (s *SinkSuite) TestRouterDefault(c *C) {
+	even := new(sliceEncoder)
+	r := NewRouter(parity, map[string]Encoder{"even": even})
+	err := r.Encode(1)
+	c.Check(errors.Is(err, ErrNoRoute), Equals, true)
+	r.Default = new(sliceEncoder)
+	c.Assert(r.Encode(1), IsNil)
+	c.Check(r.Default.(*sliceEncoder).values, DeepEquals, []interface{}{1})
+}
+
+func (s *SinkSuite) TestTee(c *C) {
+	a, b := new(closingEncoder), new(closingEncoder)
+	t := NewTee(a, b)
+	c.Assert(t.Encode(1), IsNil)
+	c.Assert(t.Close(), IsNil)
+	c.Check(a.values, DeepEquals, []interface{}{1})
+	c.Check(b.values, DeepEquals, []interface{}{1})
+	c.Check(a.closed && b.closed, Equals, true)
+}
+
+func (s *SinkSuite) TestRouterBatch(c *C) {
+	even := new(closingEncoder)
+	r := NewRouter(parity, map[string]Encoder{"even": even})
+	c.Check(errors.Is(r.EncodeBatch(intRange(3)), ErrNoRoute), Equals, true)
+	c.Check(even.values, HasLen, 0)
+	r.Default = NewTee(new(sliceEncoder))
+	c.Assert(r.EncodeBatch(intRange(5)), IsNil)
+	c.Check(even.values, DeepEquals, []interface{}{0, 2, 4})
+	c.Check(r.Default.(Tee)[0].(*sliceEncoder).values, DeepEquals, []interface{}{1, 3})
+}
+
+func (s *SinkSuite) TestTeeBatch(c *C) {
+	a, b := new(sliceEncoder), new(batchRecorder)
+	c.Assert(NewTee(a, b).EncodeBatch(intRange(3)), IsNil)
+	c.Check(a.values, DeepEquals, intRange(3))
+	c.Check(b.batches, DeepEquals, [][]interface{}{intRange(3)})
+}
+
+func (s *SinkSuite) TestProcessRouter(c *C) {
+	even, odd, dead := new(closingEncoder), new(closingEncoder), new(closingEncoder)
+	r := NewRouter(parity, map[string]Encoder{"even": even, "odd": odd})
+	in := &sliceDecoder{values: intRange(10)}
+	w := &testWorker{handler: func(v interface{}) interface{} {
+		if v.(int) == 7 {
+			return Fail(errors.New("seven"))
+		}
+		return v
+	}}
+	config := &Config{Ordered: true, DeadLetter: dead}
+	c.Assert(ProcessConfig(context.Background(), in, r, w, 2, config), IsNil)
+	c.Check(even.values, DeepEquals, []interface{}{0, 2, 4, 6, 8})
+	c.Check(odd.values, DeepEquals, []interface{}{1, 3, 5, 9})
+	c.Check(even.flushed && odd.flushed, Equals, true)
+	c.Check(even.closed && odd.closed, Equals, true)
+	c.Check(dead.values, HasLen, 1)
+	c.Check(dead.flushed, Equals, true)
+	c.Check(dead.closed, Equals, false)
+}
+
+func (s *SinkSuite) TestRouterSharedSink(c *C) {
+	path := filepath.Join(c.MkDir(), "valid.json.gz")
+	valid, err := CreateJSONLinesFile(path)
+	c.Assert(err, IsNil)
+	r := NewRouter(parity, map[string]Encoder{"even": valid})
+	r.Default = valid
+	in := &sliceDecoder{values: intRange(4)}
+	c.Assert(ProcessContext(context.Background(), in, NewTee(r, r), new(testWorker), 2), IsNil)
+
+	f, err := OpenInput(path)
+	c.Assert(err, IsNil)
+	b, err := io.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Check(strings.Count(string(b), "\n"), Equals, 8)
+}
+
+type countingCloser struct {
+	sliceEncoder
+	flushes, closes int
+}
+
+func (e *countingCloser) Flush() error {
+	e.flushes++
+	return nil
+}
+
+func (e *countingCloser) Close() error {
+	e.closes++
+	return nil
+}
+
+func (s *SinkSuite) TestCloseOnce(c *C) {
+	e := new(countingCloser)
+	r := NewRouter(parity, map[string]Encoder{"even": e, "odd": e})
+	r.Default = e
+	t := NewTee(r, e, r)
+	c.Assert(t.Flush(), IsNil)
+	c.Assert(t.Close(), IsNil)
+	// Once through the Router and once directly.
+	c.Check(e.flushes, Equals, 2)
+	c.Check(e.closes, Equals, 2)
+}
+
+func (s *SinkSuite) TestJSONLinesFile(c *C) {
+	path := filepath.Join(c.MkDir(), "valid.json.gz")
+	f, err := CreateJSONLinesFile(path)
+	c.Assert(err, IsNil)
+	c.Assert(f.Encode(map[string]int{"port": 443}), IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	r, err := OpenInput(path)
+	c.Assert(err, IsNil)
+	b, err := io.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Check(string(b), Equals, "{\"port\":443}\n")
+}
+
+func (s *SinkSuite) TestCompressedFileFlush(c *C) {
+	for _, name := range []string{"valid.json.gz", "valid.json.zst"} {
+		path := filepath.Join(c.MkDir(), name)
+		f, err := CreateJSONLinesFile(path)
+		c.Assert(err, IsNil)
+		c.Assert(f.EncodeBatch(intRange(100)), IsNil)
+		c.Assert(f.Flush(), IsNil)
+		info, err := os.Stat(path)
+		c.Assert(err, IsNil)
+		c.Check(info.Size() > 0, Equals, true, Commentf(name))
+		c.Assert(f.Close(), IsNil)
+	}
+}
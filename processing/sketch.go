@@ -0,0 +1,224 @@
+package processing
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// CountMinSketch estimates how often each key occurs in a stream using a
+// fixed amount of memory. Estimates never undercount, and overcount by at
+// most a fraction epsilon of the total with probability 1-delta for a
+// sketch built by NewCountMinSketch(epsilon, delta).
+type CountMinSketch struct {
+	width  uint64
+	depth  uint64
+	counts []uint64
+}
+
+func NewCountMinSketch(epsilon, delta float64) *CountMinSketch {
+	width := uint64(math.Ceil(math.E / epsilon))
+	depth := uint64(math.Ceil(math.Log(1 / delta)))
+	if depth == 0 {
+		depth = 1
+	}
+	return &CountMinSketch{
+		width:  width,
+		depth:  depth,
+		counts: make([]uint64, width*depth),
+	}
+}
+
+// Add counts one occurrence of key and returns its new estimated count.
+func (s *CountMinSketch) Add(key []byte) uint64 {
+	h1, h2 := hashPair(key)
+	estimate := uint64(math.MaxUint64)
+	for i := uint64(0); i < s.depth; i++ {
+		cell := i*s.width + (h1+i*h2)%s.width
+		s.counts[cell]++
+		if s.counts[cell] < estimate {
+			estimate = s.counts[cell]
+		}
+	}
+	return estimate
+}
+
+// Count returns the estimated count of key.
+func (s *CountMinSketch) Count(key []byte) uint64 {
+	h1, h2 := hashPair(key)
+	estimate := uint64(math.MaxUint64)
+	for i := uint64(0); i < s.depth; i++ {
+		if c := s.counts[i*s.width+(h1+i*h2)%s.width]; c < estimate {
+			estimate = c
+		}
+	}
+	return estimate
+}
+
+// Merge adds the counts of other, which must have the same dimensions.
+func (s *CountMinSketch) Merge(other *CountMinSketch) error {
+	if s.width != other.width || s.depth != other.depth {
+		return ErrReducerMismatch
+	}
+	for i, c := range other.counts {
+		s.counts[i] += c
+	}
+	return nil
+}
+
+// TopKEntry is a value and its estimated count.
+type TopKEntry struct {
+	Value string `json:"value"`
+	Count uint64 `json:"count"`
+}
+
+// TopK is a Reducer that tracks the k most frequent values, counted with a
+// CountMinSketch.
+type TopK struct {
+	k          int
+	sketch     *CountMinSketch
+	candidates map[string]uint64
+}
+
+// NewTopK returns a TopK tracking k values, with a sketch accurate to
+// epsilon with probability 1-delta.
+func NewTopK(k int, epsilon, delta float64) *TopK {
+	return &TopK{
+		k:          k,
+		sketch:     NewCountMinSketch(epsilon, delta),
+		candidates: make(map[string]uint64),
+	}
+}
+
+func (t *TopK) Add(v interface{}) {
+	key := fmt.Sprint(v)
+	t.offer(key, t.sketch.Add([]byte(key)))
+}
+
+// offer makes key a candidate if its count beats the smallest candidate's.
+func (t *TopK) offer(key string, count uint64) {
+	if _, ok := t.candidates[key]; ok || len(t.candidates) < t.k {
+		t.candidates[key] = count
+		return
+	}
+	minKey, minCount := "", uint64(math.MaxUint64)
+	for candidate, c := range t.candidates {
+		if c < minCount {
+			minKey, minCount = candidate, c
+		}
+	}
+	if count > minCount {
+		delete(t.candidates, minKey)
+		t.candidates[key] = count
+	}
+}
+
+func (t *TopK) Merge(other Reducer) error {
+	o, ok := other.(*TopK)
+	if !ok {
+		return ErrReducerMismatch
+	}
+	if err := t.sketch.Merge(o.sketch); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(t.candidates)+len(o.candidates))
+	for key := range t.candidates {
+		keys = append(keys, key)
+	}
+	for key := range o.candidates {
+		keys = append(keys, key)
+	}
+	t.candidates = make(map[string]uint64)
+	for _, key := range keys {
+		t.offer(key, t.sketch.Count([]byte(key)))
+	}
+	return nil
+}
+
+// Top returns the tracked values, most frequent first.
+func (t *TopK) Top() []TopKEntry {
+	entries := make([]TopKEntry, 0, len(t.candidates))
+	for value, count := range t.candidates {
+		entries = append(entries, TopKEntry{Value: value, Count: count})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Value < entries[j].Value
+	})
+	return entries
+}
+
+func (t *TopK) Report() interface{} {
+	return t.Top()
+}
+
+// HyperLogLog is a Reducer that estimates the number of distinct values
+// using 2^precision bytes of memory, with a standard error of about
+// 1.04/sqrt(2^precision).
+type HyperLogLog struct {
+	precision uint8
+	registers []uint8
+}
+
+// NewHyperLogLog returns a HyperLogLog with the given precision, between 4
+// and 18.
+func NewHyperLogLog(precision uint8) *HyperLogLog {
+	if precision < 4 {
+		precision = 4
+	}
+	if precision > 18 {
+		precision = 18
+	}
+	return &HyperLogLog{
+		precision: precision,
+		registers: make([]uint8, 1<<precision),
+	}
+}
+
+func (h *HyperLogLog) Add(v interface{}) {
+	_, x := hashPair([]byte(fmt.Sprint(v)))
+	idx := x >> (64 - h.precision)
+	rho := uint8(bits.LeadingZeros64(x<<h.precision|1<<(h.precision-1))) + 1
+	if rho > h.registers[idx] {
+		h.registers[idx] = rho
+	}
+}
+
+// Estimate returns the estimated number of distinct values.
+func (h *HyperLogLog) Estimate() uint64 {
+	m := float64(len(h.registers))
+	sum, zeros := 0.0, 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// Linear counting is more accurate for small cardinalities.
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+func (h *HyperLogLog) Merge(other Reducer) error {
+	o, ok := other.(*HyperLogLog)
+	if !ok || o.precision != h.precision {
+		return ErrReducerMismatch
+	}
+	for i, r := range o.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+	return nil
+}
+
+func (h *HyperLogLog) Report() interface{} {
+	return h.Estimate()
+}
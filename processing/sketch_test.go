@@ -0,0 +1,86 @@
+package processing
+
+import (
+	"strconv"
+
+	. "gopkg.in/check.v1"
+)
+
+type SketchSuite struct{}
+
+var _ = Suite(&SketchSuite{})
+
+func (s *SketchSuite) TestCountMinNeverUndercounts(c *C) {
+	sketch := NewCountMinSketch(0.01, 0.01)
+	for i := 0; i < 1000; i++ {
+		for j := 0; j <= i%10; j++ {
+			sketch.Add([]byte(strconv.Itoa(i)))
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		count := sketch.Count([]byte(strconv.Itoa(i)))
+		c.Check(count >= uint64(i%10+1), Equals, true)
+	}
+}
+
+func (s *SketchSuite) TestTopK(c *C) {
+	top := NewTopK(3, 0.001, 0.01)
+	for i := 0; i < 2000; i++ {
+		top.Add(strconv.Itoa(i))
+	}
+	for i := 0; i < 100; i++ {
+		top.Add("a")
+		if i < 80 {
+			top.Add("b")
+		}
+		if i < 60 {
+			top.Add(771)
+		}
+	}
+	entries := top.Top()
+	c.Assert(entries, HasLen, 3)
+	c.Check(entries[0].Value, Equals, "a")
+	c.Check(entries[1].Value, Equals, "b")
+	c.Check(entries[2].Value, Equals, "771")
+	c.Check(entries[0].Count >= 100, Equals, true)
+}
+
+func (s *SketchSuite) TestTopKMerge(c *C) {
+	a, b := NewTopK(2, 0.001, 0.01), NewTopK(2, 0.001, 0.01)
+	for i := 0; i < 10; i++ {
+		a.Add("x")
+		b.Add("y")
+		b.Add("y")
+		a.Add("z")
+	}
+	b.Add("z")
+	c.Assert(a.Merge(b), IsNil)
+	entries := a.Top()
+	c.Assert(entries, HasLen, 2)
+	c.Check(entries[0], Equals, TopKEntry{Value: "y", Count: 20})
+	c.Check(entries[1], Equals, TopKEntry{Value: "z", Count: 11})
+	c.Check(a.Merge(NewTopK(2, 0.1, 0.1)), Equals, ErrReducerMismatch)
+	c.Check(a.Merge(NewCounter()), Equals, ErrReducerMismatch)
+}
+
+func (s *SketchSuite) TestHyperLogLog(c *C) {
+	h := NewHyperLogLog(14)
+	c.Check(h.Estimate(), Equals, uint64(0))
+	for i := 0; i < 100000; i++ {
+		h.Add(i % 50000)
+	}
+	estimate := float64(h.Estimate())
+	c.Check(estimate > 48500 && estimate < 51500, Equals, true, Commentf("estimate %v", estimate))
+}
+
+func (s *SketchSuite) TestHyperLogLogMerge(c *C) {
+	a, b := NewHyperLogLog(12), NewHyperLogLog(12)
+	for i := 0; i < 1000; i++ {
+		a.Add(i)
+		b.Add(i + 500)
+	}
+	c.Assert(a.Merge(b), IsNil)
+	estimate := float64(a.Estimate())
+	c.Check(estimate > 1425 && estimate < 1575, Equals, true, Commentf("estimate %v", estimate))
+	c.Check(a.Merge(NewHyperLogLog(14)), Equals, ErrReducerMismatch)
+}
@@ -0,0 +1,295 @@
+package processing
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Flow runs a chain of stages connected by bounded channels, such as
+//
+//	f := NewFlow(ctx, nil)
+//	hosts := Source(f, decoder, 0)
+//	certs := FlatMap(hosts, StageOptions{Workers: 4}, extractCertificates)
+//	valid := Filter(certs, StageOptions{}, isValid)
+//	Sink(Batch(valid, 100, time.Second), encoder)
+//	err := f.Wait()
+//
+// Each stage runs its own goroutines, so a slow stage only holds back its
+// upstream once its buffer is full. Stages with more than one worker call
+// their function concurrently and do not preserve order.
+type Flow struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	deadLetter Encoder
+	deadMu     sync.Mutex
+
+	errOnce sync.Once
+	err     error
+}
+
+// StageOptions configures a single stage. Workers defaults to one and
+// Buffer, the capacity of the stage's output channel, to four per worker.
+type StageOptions struct {
+	Workers uint
+	Buffer  uint
+}
+
+func (o StageOptions) workers() uint {
+	if o.Workers == 0 {
+		return 1
+	}
+	return o.Workers
+}
+
+func (o StageOptions) buffer() uint {
+	if o.Buffer == 0 {
+		return o.workers() * 4
+	}
+	return o.Buffer
+}
+
+// Stream is the output of a stage, to be consumed by exactly one further
+// stage or Sink.
+type Stream[T any] struct {
+	flow *Flow
+	c    chan T
+}
+
+// NewFlow returns an empty flow. If deadLetter is non-nil, records that a
+// stage function fails on are written to it as *RecordError values;
+// otherwise the first such error stops the flow.
+func NewFlow(ctx context.Context, deadLetter Encoder) *Flow {
+	f := &Flow{deadLetter: deadLetter}
+	f.ctx, f.cancel = context.WithCancel(ctx)
+	return f
+}
+
+// Wait waits for every stage to finish and returns the first error, if any.
+func (f *Flow) Wait() error {
+	f.wg.Wait()
+	f.errOnce.Do(func() {
+		f.err = f.ctx.Err()
+	})
+	f.cancel()
+	return f.err
+}
+
+func (f *Flow) fail(err error) {
+	f.errOnce.Do(func() {
+		f.err = err
+		f.cancel()
+	})
+}
+
+// reject handles a stage function failing on v.
+func (f *Flow) reject(err error, v interface{}) {
+	if f.deadLetter == nil {
+		f.fail(err)
+		return
+	}
+	f.deadMu.Lock()
+	defer f.deadMu.Unlock()
+	if encErr := f.deadLetter.Encode(recordError(err, v)); encErr != nil {
+		f.fail(encErr)
+	}
+}
+
+// stage starts opts.workers() goroutines running fn and closes the returned
+// stream once all of them have returned.
+func stage[T any](f *Flow, opts StageOptions, fn func(out chan<- T)) *Stream[T] {
+	s := &Stream[T]{flow: f, c: make(chan T, opts.buffer())}
+	var wg sync.WaitGroup
+	for i := uint(0); i < opts.workers(); i++ {
+		wg.Add(1)
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			defer wg.Done()
+			fn(s.c)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(s.c)
+	}()
+	return s
+}
+
+// send delivers v unless the flow is stopped first.
+func send[T any](f *Flow, c chan<- T, v T) bool {
+	select {
+	case c <- v:
+		return true
+	case <-f.ctx.Done():
+		return false
+	}
+}
+
+// Source starts a stage that reads records from d until it is exhausted.
+func Source[T any](f *Flow, d TypedDecoder[T], buffer uint) *Stream[T] {
+	return stage(f, StageOptions{Buffer: buffer}, func(out chan<- T) {
+		for f.ctx.Err() == nil {
+			v, err := d.DecodeNext()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				f.fail(err)
+				return
+			}
+			if !send(f, out, v) {
+				return
+			}
+		}
+	})
+}
+
+// Map starts a stage that replaces each record with fn's result.
+func Map[In, Out any](s *Stream[In], opts StageOptions, fn func(In) (Out, error)) *Stream[Out] {
+	f := s.flow
+	return stage(f, opts, func(out chan<- Out) {
+		for v := range s.c {
+			if f.ctx.Err() != nil {
+				return
+			}
+			result, err := fn(v)
+			if err != nil {
+				f.reject(err, v)
+				continue
+			}
+			if !send(f, out, result) {
+				return
+			}
+		}
+	})
+}
+
+// Filter starts a stage that drops the records for which keep returns false.
+func Filter[T any](s *Stream[T], opts StageOptions, keep func(T) (bool, error)) *Stream[T] {
+	f := s.flow
+	return stage(f, opts, func(out chan<- T) {
+		for v := range s.c {
+			if f.ctx.Err() != nil {
+				return
+			}
+			ok, err := keep(v)
+			if err != nil {
+				f.reject(err, v)
+				continue
+			}
+			if ok && !send(f, out, v) {
+				return
+			}
+		}
+	})
+}
+
+// FlatMap starts a stage that replaces each record with any number of
+// records, such as one record per certificate in a chain.
+func FlatMap[In, Out any](s *Stream[In], opts StageOptions, fn func(In) ([]Out, error)) *Stream[Out] {
+	f := s.flow
+	return stage(f, opts, func(out chan<- Out) {
+		for v := range s.c {
+			if f.ctx.Err() != nil {
+				return
+			}
+			results, err := fn(v)
+			if err != nil {
+				f.reject(err, v)
+				continue
+			}
+			for _, result := range results {
+				if !send(f, out, result) {
+					return
+				}
+			}
+		}
+	})
+}
+
+// Batch starts a stage that groups records into slices of up to size
+// records. A partial batch is emitted once its first record has waited
+// maxDelay, if maxDelay is positive, and when the input ends.
+func Batch[T any](s *Stream[T], size int, maxDelay time.Duration) *Stream[[]T] {
+	f := s.flow
+	return stage(f, StageOptions{}, func(out chan<- []T) {
+		var (
+			batch []T
+			timer *time.Timer
+			due   <-chan time.Time
+		)
+		flush := func() bool {
+			if timer != nil {
+				timer.Stop()
+				timer, due = nil, nil
+			}
+			if len(batch) == 0 {
+				return true
+			}
+			b := batch
+			batch = nil
+			return send(f, out, b)
+		}
+		for {
+			select {
+			case v, ok := <-s.c:
+				if !ok {
+					flush()
+					return
+				}
+				batch = append(batch, v)
+				if len(batch) >= size {
+					if !flush() {
+						return
+					}
+				} else if len(batch) == 1 && maxDelay > 0 {
+					timer = time.NewTimer(maxDelay)
+					due = timer.C
+				}
+			case <-due:
+				timer, due = nil, nil
+				if !flush() {
+					return
+				}
+			case <-f.ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+// Sink starts the terminal stage, which encodes every record of s with e.
+// When the flow ends, however it ends, e is flushed and closed if it has
+// Flush and Close methods, such as a FileEncoder adapted by NewTypedEncoder.
+func Sink[T any](s *Stream[T], e TypedEncoder[T]) {
+	f := s.flow
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		defer func() {
+			if fl, ok := e.(flusher); ok {
+				if err := fl.Flush(); err != nil {
+					f.fail(err)
+				}
+			}
+			if c, ok := e.(io.Closer); ok {
+				if err := c.Close(); err != nil {
+					f.fail(err)
+				}
+			}
+		}()
+		for v := range s.c {
+			if f.ctx.Err() != nil {
+				return
+			}
+			if err := e.Encode(v); err != nil {
+				f.fail(err)
+				return
+			}
+		}
+	}()
+}
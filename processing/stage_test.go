@@ -0,0 +1,121 @@
+package processing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type StageSuite struct{}
+
+var _ = Suite(&StageSuite{})
+
+type intDecoder struct {
+	next, end int
+}
+
+func (d *intDecoder) DecodeNext() (int, error) {
+	if d.next >= d.end {
+		return 0, io.EOF
+	}
+	d.next++
+	return d.next - 1, nil
+}
+
+type batchCollector struct {
+	batches [][]int
+}
+
+func (b *batchCollector) Encode(v []int) error {
+	b.batches = append(b.batches, v)
+	return nil
+}
+
+func (s *StageSuite) TestChain(c *C) {
+	f := NewFlow(context.Background(), nil)
+	ints := Source[int](f, &intDecoder{end: 50}, 0)
+	doubled := Map(ints, StageOptions{Workers: 4}, func(i int) (int, error) {
+		return 2 * i, nil
+	})
+	small := Filter(doubled, StageOptions{Workers: 2}, func(i int) (bool, error) {
+		return i < 20, nil
+	})
+	pairs := FlatMap(small, StageOptions{}, func(i int) ([]int, error) {
+		return []int{i, i + 1}, nil
+	})
+	out := new(batchCollector)
+	Sink[[]int](Batch(pairs, 6, time.Second), out)
+	c.Assert(f.Wait(), IsNil)
+
+	var all []int
+	for i, b := range out.batches {
+		if i < len(out.batches)-1 {
+			c.Check(b, HasLen, 6)
+		}
+		all = append(all, b...)
+	}
+	sort.Ints(all)
+	c.Check(all, DeepEquals, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19})
+}
+
+func (s *StageSuite) TestBatchDelay(c *C) {
+	f := NewFlow(context.Background(), nil)
+	ints := make(chan int)
+	src := &Stream[int]{flow: f, c: ints}
+	batches := Batch(src, 100, 5*time.Millisecond)
+	ints <- 1
+	ints <- 2
+	select {
+	case b := <-batches.c:
+		c.Check(b, DeepEquals, []int{1, 2})
+	case <-time.After(time.Second):
+		c.Fatal("partial batch was not flushed")
+	}
+	close(ints)
+	c.Assert(f.Wait(), IsNil)
+}
+
+func (s *StageSuite) TestStageError(c *C) {
+	boom := errors.New("boom")
+	f := NewFlow(context.Background(), nil)
+	ints := Source[int](f, &intDecoder{end: 1000}, 0)
+	failing := Map(ints, StageOptions{Workers: 2}, func(i int) (int, error) {
+		if i == 10 {
+			return 0, boom
+		}
+		return i, nil
+	})
+	Sink[int](failing, NewTypedEncoder[int](new(sliceEncoder)))
+	c.Check(f.Wait(), Equals, boom)
+}
+
+func (s *StageSuite) TestStageDeadLetter(c *C) {
+	dead := new(sliceEncoder)
+	f := NewFlow(context.Background(), dead)
+	ints := Source[int](f, &intDecoder{end: 10}, 0)
+	odd := Filter(ints, StageOptions{}, func(i int) (bool, error) {
+		if i == 3 {
+			return false, errors.New("unlucky")
+		}
+		return i%2 == 1, nil
+	})
+	out := new(sliceEncoder)
+	Sink[int](odd, NewTypedEncoder[int](out))
+	c.Assert(f.Wait(), IsNil)
+	c.Check(out.values, DeepEquals, []interface{}{1, 5, 7, 9})
+	c.Assert(dead.values, HasLen, 1)
+	c.Check(dead.values[0].(*RecordError).Input, Equals, 3)
+}
+
+func (s *StageSuite) TestSinkCloses(c *C) {
+	f := NewFlow(context.Background(), nil)
+	out := new(closingEncoder)
+	Sink(Source[int](f, &intDecoder{end: 3}, 0), NewTypedEncoder[int](out))
+	c.Assert(f.Wait(), IsNil)
+	c.Check(out.values, DeepEquals, []interface{}{0, 1, 2})
+	c.Check(out.flushed && out.closed, Equals, true)
+}
@@ -0,0 +1,274 @@
+package processing
+
+import (
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FailureRecorder is implemented by Workers that want to count the records
+// Process fails on behalf of their handlers, such as handlers that panic or
+// time out. Such records are never seen by the handler's own code, so a
+// Worker without RecordFailure undercounts Failure.
+type FailureRecorder interface {
+	RecordFailure(*RecordError)
+}
+
+// wrappedWorker is a Worker whose handlers are those of an inner Worker,
+// changed by wrap. It forwards RecordFailure to the inner Worker, which
+// embedding alone would hide.
+type wrappedWorker struct {
+	Worker
+	wrap func(Handler) Handler
+}
+
+func wrapWorker(w Worker, wrap func(Handler) Handler) wrappedWorker {
+	return wrappedWorker{Worker: w, wrap: wrap}
+}
+
+func (w wrappedWorker) MakeHandler(id uint) Handler {
+	return w.wrap(w.Worker.MakeHandler(id))
+}
+
+func (w wrappedWorker) RecordFailure(rerr *RecordError) {
+	if fr, ok := w.Worker.(FailureRecorder); ok {
+		fr.RecordFailure(rerr)
+	}
+}
+
+// mapResults returns a wrap function for wrapWorker that replaces the
+// results of a handler by f of them. Failed and skipped records are passed
+// through unchanged.
+func mapResults(f func(interface{}) interface{}) func(Handler) Handler {
+	return func(handler Handler) Handler {
+		return func(v interface{}) interface{} {
+			result := handler(v)
+			if _, ok := result.(*RecordError); ok || result == Skip {
+				return result
+			}
+			return f(result)
+		}
+	}
+}
+
+// Counters is a concurrency-safe implementation of the Success, Failure and
+// Total methods of Worker, and of FailureRecorder. It is meant to be
+// embedded in Worker implementations.
+type Counters struct {
+	success atomic.Uint64
+	failure atomic.Uint64
+}
+
+func (c *Counters) AddSuccess() {
+	c.success.Add(1)
+}
+
+func (c *Counters) AddFailure() {
+	c.failure.Add(1)
+}
+
+func (c *Counters) Success() uint {
+	return uint(c.success.Load())
+}
+
+func (c *Counters) Failure() uint {
+	return uint(c.failure.Load())
+}
+
+func (c *Counters) Total() uint {
+	return c.Success() + c.Failure()
+}
+
+func (c *Counters) RecordFailure(*RecordError) {
+	c.AddFailure()
+}
+
+// outcome is the result of a single handler call. err is the error
+// returned by the handler, while failure is set when the run failed the call
+// itself because it panicked or timed out. abandoned is set when the call
+// may still be running, in which case it owns its handler and id.
+type outcome[Out any] struct {
+	value     Out
+	err       error
+	failure   *RecordError
+	abandoned bool
+}
+
+// callHandler runs handler on v, converting a panic into a failure.
+func callHandler[In, Out any](handler TypedHandler[In, Out], v In) (o outcome[Out]) {
+	defer func() {
+		if x := recover(); x != nil {
+			o.failure = &RecordError{
+				Input: v,
+				Err:   &PanicError{Value: x, Stack: debug.Stack()},
+			}
+		}
+	}()
+	o.value, o.err = handler(v)
+	return o
+}
+
+// handle runs handler, created for id, on v according to the panic and
+// timeout settings of the run. If the call is abandoned, id is released once
+// it returns.
+func (p *process[In, Out]) handle(id uint, handler TypedHandler[In, Out], v In) outcome[Out] {
+	timeout := p.config.HandlerTimeout
+	if timeout <= 0 {
+		if p.config.PropagatePanics {
+			var o outcome[Out]
+			o.value, o.err = handler(v)
+			return o
+		}
+		return callHandler(handler, v)
+	}
+	done := make(chan outcome[Out], 1)
+	go func() {
+		done <- callHandler(handler, v)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case o := <-done:
+		return o
+	case <-timer.C:
+		err = ErrHandlerTimeout
+	case <-p.ctx.Done():
+		err = p.ctx.Err()
+	}
+	go func() {
+		<-done
+		p.ids.release(id)
+	}()
+	return outcome[Out]{failure: &RecordError{Input: v, Err: err}, abandoned: true}
+}
+
+// idPool hands out the smallest handler id that is not in use.
+type idPool struct {
+	mu   sync.Mutex
+	free []uint
+	next uint
+}
+
+func (ip *idPool) acquire() uint {
+	ip.mu.Lock()
+	defer ip.mu.Unlock()
+	if len(ip.free) == 0 {
+		ip.next++
+		return ip.next - 1
+	}
+	min := 0
+	for i, id := range ip.free {
+		if id < ip.free[min] {
+			min = i
+		}
+	}
+	id := ip.free[min]
+	ip.free = append(ip.free[:min], ip.free[min+1:]...)
+	return id
+}
+
+func (ip *idPool) release(id uint) {
+	ip.mu.Lock()
+	ip.free = append(ip.free, id)
+	ip.mu.Unlock()
+}
+
+// makeHandler calls MakeHandler of the Worker. Adaptive runs and handler
+// timeouts make handlers from several goroutines, but a Worker only ever
+// sees one call at a time.
+func (p *process[In, Out]) makeHandler(id uint) TypedHandler[In, Out] {
+	p.handlerMu.Lock()
+	defer p.handlerMu.Unlock()
+	return p.w.MakeHandler(id)
+}
+
+// startWorker starts a worker goroutine with a fresh handler.
+func (p *process[In, Out]) startWorker() {
+	id := p.ids.acquire()
+	handler := p.makeHandler(id)
+	p.active.Add(1)
+	p.workers.Add(1)
+	go func() {
+		defer p.workers.Done()
+		defer p.active.Add(-1)
+		p.runWorker(id, handler)
+	}()
+}
+
+// recordError wraps an error returned by a handler for input v.
+func recordError(err error, v interface{}) *RecordError {
+	rerr, ok := err.(*RecordError)
+	if !ok {
+		return &RecordError{Input: v, Err: err}
+	}
+	if rerr.Input == nil {
+		rerr.Input = v
+	}
+	return rerr
+}
+
+// runWorker handles records until the input is exhausted or the worker is
+// asked to stop. Its id is released for reuse once it returns, unless an
+// abandoned call still holds it.
+func (p *process[In, Out]) runWorker(id uint, handler TypedHandler[In, Out]) {
+	owned := true
+	defer func() {
+		if owned {
+			p.ids.release(id)
+		}
+	}()
+	for {
+		var (
+			r  record[In]
+			ok bool
+		)
+		select {
+		case r, ok = <-p.processQueue:
+		case <-p.shrink:
+			return
+		}
+		if !ok {
+			return
+		}
+		if p.ctx.Err() != nil {
+			continue
+		}
+		if p.config.RateLimit != nil {
+			if err := p.config.RateLimit.Wait(p.ctx, r.value); err != nil {
+				continue
+			}
+		}
+		began := time.Now()
+		o := p.handle(id, handler, r.value)
+		p.busy.Add(int64(time.Since(began)))
+		p.handled.Add(1)
+		if o.abandoned {
+			// The abandoned call may still be running and owns handler and
+			// its id, which handle releases once it returns.
+			if p.ctx.Err() != nil {
+				owned = false
+				return
+			}
+			id = p.ids.acquire()
+			handler = p.makeHandler(id)
+		}
+		if p.ctx.Err() != nil {
+			continue
+		}
+		res := result[Out]{seq: r.seq, value: o.value}
+		switch {
+		case o.failure != nil:
+			if fr, ok := p.w.(FailureRecorder); ok {
+				fr.RecordFailure(o.failure)
+			}
+			res.err = o.failure
+		case o.err == ErrSkipRecord:
+			res.skip = true
+		case o.err != nil:
+			res.err = recordError(o.err, r.value)
+		}
+		p.outputQueue <- res
+	}
+}
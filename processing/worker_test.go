@@ -0,0 +1,220 @@
+package processing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type WorkerSuite struct{}
+
+var _ = Suite(&WorkerSuite{})
+
+type countingWorker struct {
+	Counters
+	handler Handler
+
+	mu  sync.Mutex
+	ids []uint
+}
+
+func (w *countingWorker) MakeHandler(id uint) Handler {
+	w.mu.Lock()
+	w.ids = append(w.ids, id)
+	w.mu.Unlock()
+	return w.handler
+}
+
+func (w *countingWorker) Done() {}
+
+func (s *WorkerSuite) TestRecoverPanics(c *C) {
+	w := &countingWorker{handler: func(v interface{}) interface{} {
+		if v.(int) == 3 {
+			panic("bad record")
+		}
+		return v
+	}}
+	out := new(sliceEncoder)
+	config := &Config{Ordered: true}
+	err := ProcessConfig(context.Background(), &sliceDecoder{values: intRange(5)}, out, w, 2, config)
+	c.Assert(err, IsNil)
+	c.Assert(out.values, HasLen, 5)
+	rerr, ok := out.values[3].(*RecordError)
+	c.Assert(ok, Equals, true)
+	c.Check(rerr.Input, Equals, 3)
+	var panicErr *PanicError
+	c.Assert(errors.As(rerr, &panicErr), Equals, true)
+	c.Check(panicErr.Value, Equals, "bad record")
+	c.Check(len(panicErr.Stack) > 0, Equals, true)
+	c.Check(w.Failure(), Equals, uint(1))
+
+	b, err := json.Marshal(rerr)
+	c.Assert(err, IsNil)
+	var encoded map[string]interface{}
+	c.Assert(json.Unmarshal(b, &encoded), IsNil)
+	c.Check(encoded["input"], Equals, 3.0)
+	c.Check(encoded["error"], Equals, "processing: handler panicked: bad record")
+	c.Check(encoded["stack"], Not(Equals), "")
+}
+
+func (s *WorkerSuite) TestHandlerTimeout(c *C) {
+	release := make(chan struct{})
+	defer close(release)
+	w := &countingWorker{handler: func(v interface{}) interface{} {
+		if v.(int) == 1 {
+			<-release
+		}
+		return v
+	}}
+	out := new(sliceEncoder)
+	config := &Config{HandlerTimeout: 20 * time.Millisecond, Ordered: true}
+	err := ProcessConfig(context.Background(), &sliceDecoder{values: intRange(4)}, out, w, 1, config)
+	c.Assert(err, IsNil)
+	c.Assert(out.values, HasLen, 4)
+	rerr, ok := out.values[1].(*RecordError)
+	c.Assert(ok, Equals, true)
+	c.Check(rerr.Err, Equals, ErrHandlerTimeout)
+	c.Check(out.values[2], Equals, 2)
+	c.Check(w.Failure(), Equals, uint(1))
+	c.Check(w.ids, DeepEquals, []uint{0, 1})
+}
+
+func (s *WorkerSuite) TestHandlerTimeoutReleasesId(c *C) {
+	release := make(chan struct{})
+	w := &countingWorker{handler: func(v interface{}) interface{} {
+		if v.(int) == 1 {
+			<-release
+		}
+		if v.(int) == 3 {
+			time.Sleep(200 * time.Millisecond)
+		}
+		return v
+	}}
+	in := &sliceDecoder{values: intRange(6)}
+	config := &Config{HandlerTimeout: 50 * time.Millisecond, Ordered: true}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// Let the abandoned call of record 1 return while record 3 is
+		// timing out, so that its id can be reused.
+		time.Sleep(70 * time.Millisecond)
+		close(release)
+	}()
+	err := ProcessConfig(context.Background(), in, new(sliceEncoder), w, 1, config)
+	wg.Wait()
+	c.Assert(err, IsNil)
+	c.Check(w.Failure(), Equals, uint(2))
+	c.Check(w.ids, DeepEquals, []uint{0, 1, 0})
+}
+
+func (s *WorkerSuite) TestCancelReleasesIdOfAbandonedCall(c *C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	release, returned := make(chan struct{}), make(chan struct{})
+	w := &countingWorker{handler: func(v interface{}) interface{} {
+		cancel()
+		<-release
+		close(returned)
+		return v
+	}}
+	p := newProcess(&Pipeline[interface{}, interface{}]{
+		Decoder: &sliceDecoder{values: intRange(1)},
+		Encoder: new(sliceEncoder),
+		Worker:  untypedWorker{w},
+		Workers: 1,
+		Config:  &Config{HandlerTimeout: time.Minute},
+	})
+	c.Assert(p.run(ctx), Equals, context.Canceled)
+	// The abandoned call still holds id 0.
+	c.Check(p.ids.acquire(), Equals, uint(1))
+	close(release)
+	<-returned
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		p.ids.mu.Lock()
+		free := len(p.ids.free)
+		p.ids.mu.Unlock()
+		if free > 0 {
+			break
+		}
+	}
+	c.Check(p.ids.acquire(), Equals, uint(0))
+}
+
+func (s *WorkerSuite) TestDeadLetter(c *C) {
+	notTLS := errors.New("not a TLS record")
+	w := &countingWorker{handler: func(v interface{}) interface{} {
+		if v.(int)%2 == 1 {
+			return Fail(notTLS)
+		}
+		return v
+	}}
+	out, dead := new(sliceEncoder), new(sliceEncoder)
+	config := &Config{Ordered: true, DeadLetter: dead}
+	err := ProcessConfig(context.Background(), &sliceDecoder{values: intRange(6)}, out, w, 3, config)
+	c.Assert(err, IsNil)
+	c.Check(out.values, DeepEquals, []interface{}{0, 2, 4})
+	c.Assert(dead.values, HasLen, 3)
+	for i, v := range dead.values {
+		rerr := v.(*RecordError)
+		c.Check(rerr.Input, Equals, 2*i+1)
+		c.Check(rerr.Err, Equals, notTLS)
+	}
+}
+
+func (s *WorkerSuite) TestMalformedRecords(c *C) {
+	const in = "{\"ip\":\"1.2.3.4\"}\n{bad json\n{\"ip\":\"5.6.7.8\"}\n"
+	ips := []interface{}{
+		map[string]interface{}{"ip": "1.2.3.4"},
+		map[string]interface{}{"ip": "5.6.7.8"},
+	}
+
+	out, dead := new(sliceEncoder), new(sliceEncoder)
+	config := &Config{Ordered: true, DeadLetter: dead}
+	err := ProcessConfig(context.Background(), NewJSONLinesDecoder(strings.NewReader(in)), out, new(testWorker), 2, config)
+	c.Assert(err, IsNil)
+	c.Check(out.values, DeepEquals, ips)
+	c.Assert(dead.values, HasLen, 1)
+	var malformed *MalformedRecordError
+	c.Assert(errors.As(dead.values[0].(*RecordError).Err, &malformed), Equals, true)
+	c.Check(malformed.Line, Equals, 2)
+
+	out = new(sliceEncoder)
+	config = &Config{SkipMalformed: true}
+	err = ProcessConfig(context.Background(), NewJSONLinesDecoder(strings.NewReader(in)), out, new(testWorker), 2, config)
+	c.Assert(err, IsNil)
+	c.Check(out.values, HasLen, 2)
+
+	err = ProcessConfig(context.Background(), NewJSONLinesDecoder(strings.NewReader(in)), new(sliceEncoder), new(testWorker), 2, nil)
+	c.Check(errors.As(err, &malformed), Equals, true)
+}
+
+func (s *WorkerSuite) TestWrappedWorkerRecordsFailures(c *C) {
+	p, err := NewProjection([]string{"ip"}, ArrayJoin)
+	c.Assert(err, IsNil)
+	f, err := ParseFilter("ip")
+	c.Assert(err, IsNil)
+	e := new(Enricher)
+	e.table.Store(NewPrefixTable())
+	wrappers := map[string]func(Worker) Worker{
+		"dedup": func(w Worker) Worker {
+			return NewDedupWorker(w, func(interface{}) []byte { return nil }, NewExactKeySet())
+		},
+		"filter":     func(w Worker) Worker { return NewFilterWorker(w, f) },
+		"projection": func(w Worker) Worker { return NewProjectionWorker(w, p) },
+		"enrich":     func(w Worker) Worker { return NewEnrichWorker(w, e) },
+	}
+	for name, wrap := range wrappers {
+		inner := &countingWorker{handler: func(v interface{}) interface{} {
+			panic("bad record")
+		}}
+		in := &sliceDecoder{values: []interface{}{map[string]interface{}{"ip": "192.0.2.1"}}}
+		err := ProcessConfig(context.Background(), in, new(sliceEncoder), wrap(inner), 1, nil)
+		c.Assert(err, IsNil)
+		c.Check(inner.Failure(), Equals, uint(1), Commentf(name))
+	}
+}
@@ -0,0 +1,139 @@
+package zlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// missingValue stands in for the value of a key given without one.
+const missingValue = "(MISSING)"
+
+// Entry is a single message, as passed to a Formatter.
+type Entry struct {
+	Time    time.Time
+	Level   LogLevel
+	Prefix  string
+	Message string
+	// Fields holds alternating keys and values. A key at the end without a
+	// value stands for a missing value.
+	Fields []interface{}
+}
+
+// Formatter renders log entries. A Logger calls Format with its mutex held,
+// so implementations need not be safe for concurrent use, and writes the
+// buffer out as a single write.
+type Formatter interface {
+	Format(buf *bytes.Buffer, entry *Entry)
+}
+
+// pairs calls fn for each key and value of fields.
+func pairs(fields []interface{}, fn func(key string, value interface{})) {
+	for i := 0; i < len(fields); i += 2 {
+		var value interface{} = missingValue
+		if i+1 < len(fields) {
+			value = fields[i+1]
+		}
+		fn(fmt.Sprint(fields[i]), value)
+	}
+}
+
+// TextFormatter writes one line per entry, such as
+//
+//	Oct 18 12:00:00.000 [INFO] scan: handshake complete ip=1.2.3.4
+//
+// wrapped in ANSI color codes for the level if Color is set.
+type TextFormatter struct {
+	Color bool
+}
+
+const (
+	prefixFormat = "%s [%s] %s: "
+)
+
+func (f *TextFormatter) Format(buf *bytes.Buffer, entry *Entry) {
+	if f.Color {
+		buf.Write(entry.Level.Color())
+	}
+	fmt.Fprintf(buf, prefixFormat, entry.Time.Format(time.StampMilli), entry.Level.String(), entry.Prefix)
+	buf.WriteString(entry.Message)
+	pairs(entry.Fields, func(key string, value interface{}) {
+		buf.WriteByte(' ')
+		buf.WriteString(quoteField(key))
+		buf.WriteByte('=')
+		buf.WriteString(quoteField(fmt.Sprint(value)))
+	})
+	buf.WriteByte('\n')
+	if f.Color {
+		buf.Write(reset)
+	}
+}
+
+// quoteField quotes s if it would otherwise be ambiguous in a key=value
+// pair.
+func quoteField(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\r\n=\"") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// JSONFormatter writes one JSON object per line, such as
+//
+//	{"timestamp":"2026-10-18T12:00:00.123456789Z","level":"INFO","prefix":"scan","message":"handshake complete","fields":{"ip":"1.2.3.4"}}
+//
+// The timestamp is in RFC 3339 format with nanoseconds, and fields is
+// omitted if the entry has none. Errors are rendered as their message, and
+// values that cannot be encoded as JSON as their fmt.Sprint form.
+type JSONFormatter struct{}
+
+type encodedEntry struct {
+	Timestamp string `json:"timestamp"`
+	Level     string `json:"level"`
+	Prefix    string `json:"prefix"`
+	Message   string `json:"message"`
+}
+
+func (f *JSONFormatter) Format(buf *bytes.Buffer, entry *Entry) {
+	b, _ := json.Marshal(encodedEntry{
+		Timestamp: entry.Time.Format(time.RFC3339Nano),
+		Level:     entry.Level.String(),
+		Prefix:    entry.Prefix,
+		Message:   entry.Message,
+	})
+	if len(entry.Fields) == 0 {
+		buf.Write(b)
+		buf.WriteByte('\n')
+		return
+	}
+	// Fields are written in order, so that they keep the order they were
+	// given in.
+	buf.Write(b[:len(b)-1])
+	buf.WriteString(`,"fields":{`)
+	first := true
+	pairs(entry.Fields, func(key string, value interface{}) {
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+		k, _ := json.Marshal(key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(jsonValue(value))
+	})
+	buf.WriteString("}}\n")
+}
+
+func jsonValue(value interface{}) []byte {
+	if err, ok := value.(error); ok {
+		value = err.Error()
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(value))
+	}
+	return b
+}
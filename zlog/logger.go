@@ -1,18 +1,35 @@
 package zlog
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Logger struct {
-	mu     sync.Mutex
+	// The mutex, output and level are shared with loggers derived by With
+	mu     *sync.Mutex
 	out    io.Writer
 	prefix string
 
+	// Messages above level are discarded
+	level *atomic.Uint32
+
+	// Alternating keys and values added to every message
+	fields []interface{}
+
+	// Guarded by mu
+	formatter Formatter
+
+	// Receives all messages instead of out if set, see FromSlog
+	handler slog.Handler
+
 	// Color handling
 	useColor     bool
 	currentColor color
@@ -21,10 +38,6 @@ type Logger struct {
 type LogLevel uint8
 type color []byte
 
-const (
-	prefixFormat = "%s [%s] %s: "
-)
-
 const (
 	LOG_FATAL LogLevel = iota
 	LOG_ERROR LogLevel = iota
@@ -59,10 +72,24 @@ var (
 	colors     = []color{red, magenta, yellow, green, blue, reset, reset}
 )
 
+// LevelEnv is the environment variable that sets the level of the default
+// logger, such as ZLOG_LEVEL=info.
+const LevelEnv = "ZLOG_LEVEL"
+
 var (
-	defaultLogger = New(os.Stderr, "log")
+	defaultLogger = newDefaultLogger()
 )
 
+func newDefaultLogger() *Logger {
+	logger := New(os.Stderr, "log")
+	if name := os.Getenv(LevelEnv); name != "" {
+		if level, err := ParseLevel(name); err == nil {
+			logger.SetLevel(level)
+		}
+	}
+	return logger
+}
+
 func (level LogLevel) String() string {
 	if level > LOG_TRACE {
 		level = LOG_TRACE
@@ -77,6 +104,33 @@ func (level LogLevel) Color() color {
 	return colors[level]
 }
 
+// ParseLevel returns the level with the given name, such as "warn" or
+// "TRACE".
+func ParseLevel(name string) (LogLevel, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return LogLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("zlog: unknown log level %q", name)
+}
+
+// Set implements flag.Value, so that a level can be given on the command
+// line:
+//
+//	level := zlog.LOG_INFO
+//	flag.Var(&level, "log-level", "minimum level to log")
+func (level *LogLevel) Set(name string) error {
+	l, err := ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	*level = l
+	return nil
+}
+
+// New returns a Logger that logs messages at every level in the text format,
+// in color if out is a terminal.
 func New(out io.Writer, prefix string) *Logger {
 	useColor := false
 	file, ok := out.(*os.File)
@@ -87,12 +141,66 @@ func New(out io.Writer, prefix string) *Logger {
 			useColor = true
 		}
 	}
-	logger := Logger{
+	logger := &Logger{
+		mu:       new(sync.Mutex),
 		out:      out,
 		prefix:   prefix,
+		level:    new(atomic.Uint32),
 		useColor: useColor,
+
+		formatter: &TextFormatter{Color: useColor},
 	}
-	return &logger
+	logger.level.Store(uint32(LOG_TRACE))
+	return logger
+}
+
+// SetLevel discards messages above level from now on. FATAL messages are
+// always logged. It is safe to call while the logger is in use, and applies
+// to the logger it was derived from by With and to all loggers derived from
+// it.
+func (logger *Logger) SetLevel(level LogLevel) {
+	if level > LOG_TRACE {
+		level = LOG_TRACE
+	}
+	logger.level.Store(uint32(level))
+}
+
+func (logger *Logger) Level() LogLevel {
+	return LogLevel(logger.level.Load())
+}
+
+// SetFormatter sets the format of the messages logger writes from now on.
+// Loggers derived from logger by With keep the formatter they were created
+// with.
+func (logger *Logger) SetFormatter(formatter Formatter) {
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	logger.formatter = formatter
+}
+
+// Enabled reports whether messages at level are logged.
+func (logger *Logger) Enabled(level LogLevel) bool {
+	return uint32(level) <= logger.level.Load()
+}
+
+// With returns a logger that adds the given fields, alternating keys and
+// values, to every message, after the fields of logger:
+//
+//	hostLogger := logger.With("ip", ip, "port", port)
+//	hostLogger.Infow("handshake complete", "cipher_suite", suite)
+//
+// The new logger writes to the same output as logger.
+func (logger *Logger) With(keysAndValues ...interface{}) *Logger {
+	logger.mu.Lock()
+	child := *logger
+	logger.mu.Unlock()
+	child.fields = make([]interface{}, 0, len(logger.fields)+len(keysAndValues)+1)
+	child.fields = append(child.fields, logger.fields...)
+	child.fields = append(child.fields, keysAndValues...)
+	if len(keysAndValues)%2 != 0 {
+		child.fields = append(child.fields, missingValue)
+	}
+	return &child
 }
 
 func (logger *Logger) Fatal(v ...interface{}) {
@@ -145,6 +253,31 @@ func (logger *Logger) Tracef(format string, v ...interface{}) {
 	logger.doPrintf(LOG_TRACE, format, v...)
 }
 
+func (logger *Logger) Fatalw(msg string, keysAndValues ...interface{}) {
+	logger.doPrintw(LOG_FATAL, msg, keysAndValues)
+	os.Exit(1)
+}
+
+func (logger *Logger) Errorw(msg string, keysAndValues ...interface{}) {
+	logger.doPrintw(LOG_ERROR, msg, keysAndValues)
+}
+
+func (logger *Logger) Warnw(msg string, keysAndValues ...interface{}) {
+	logger.doPrintw(LOG_WARN, msg, keysAndValues)
+}
+
+func (logger *Logger) Infow(msg string, keysAndValues ...interface{}) {
+	logger.doPrintw(LOG_INFO, msg, keysAndValues)
+}
+
+func (logger *Logger) Debugw(msg string, keysAndValues ...interface{}) {
+	logger.doPrintw(LOG_DEBUG, msg, keysAndValues)
+}
+
+func (logger *Logger) Tracew(msg string, keysAndValues ...interface{}) {
+	logger.doPrintw(LOG_TRACE, msg, keysAndValues)
+}
+
 func Fatal(v ...interface{}) {
 	defaultLogger.Fatal(v...)
 }
@@ -213,6 +346,40 @@ func (logger *Logger) Printf(level LogLevel, format string, v ...interface{}) {
 	}
 }
 
+func Fatalw(msg string, keysAndValues ...interface{}) {
+	defaultLogger.Fatalw(msg, keysAndValues...)
+}
+
+func Errorw(msg string, keysAndValues ...interface{}) {
+	defaultLogger.Errorw(msg, keysAndValues...)
+}
+
+func Warnw(msg string, keysAndValues ...interface{}) {
+	defaultLogger.Warnw(msg, keysAndValues...)
+}
+
+func Infow(msg string, keysAndValues ...interface{}) {
+	defaultLogger.Infow(msg, keysAndValues...)
+}
+
+func Debugw(msg string, keysAndValues ...interface{}) {
+	defaultLogger.Debugw(msg, keysAndValues...)
+}
+
+func Tracew(msg string, keysAndValues ...interface{}) {
+	defaultLogger.Tracew(msg, keysAndValues...)
+}
+
+// With returns a logger derived from the default logger by Logger.With.
+func With(keysAndValues ...interface{}) *Logger {
+	return defaultLogger.With(keysAndValues...)
+}
+
+// SetLevel sets the level of the default logger.
+func SetLevel(level LogLevel) {
+	defaultLogger.SetLevel(level)
+}
+
 func Print(level LogLevel, v ...interface{}) {
 	defaultLogger.Print(level, v...)
 }
@@ -230,32 +397,55 @@ func (logger *Logger) clearColor() {
 }
 
 func (logger *Logger) doPrint(level LogLevel, v ...interface{}) {
-	timestamp := time.Now().Format(time.StampMilli)
-	logger.mu.Lock()
-	defer logger.mu.Unlock()
-	// Handle color output
-	if logger.useColor {
-		logger.out.Write(colors[level])
-		defer logger.out.Write(reset)
+	if !logger.Enabled(level) {
+		return
 	}
-
-	// Write the line out
-	fmt.Fprintf(logger.out, prefixFormat, timestamp, level.String(), logger.prefix)
-	fmt.Fprint(logger.out, v...)
-	logger.out.Write([]byte{'\n'})
+	logger.output(level, fmt.Sprint(v...), nil)
 }
 
 func (logger *Logger) doPrintf(level LogLevel, format string, v ...interface{}) {
-	timestamp := time.Now().Format(time.StampMilli)
+	if !logger.Enabled(level) {
+		return
+	}
+	logger.output(level, fmt.Sprintf(format, v...), nil)
+}
+
+func (logger *Logger) doPrintw(level LogLevel, msg string, keysAndValues []interface{}) {
+	if !logger.Enabled(level) {
+		return
+	}
+	logger.output(level, msg, keysAndValues)
+}
+
+// output writes a message with the fields of the logger followed by
+// keysAndValues.
+func (logger *Logger) output(level LogLevel, msg string, keysAndValues []interface{}) {
+	entry := Entry{
+		Time:    time.Now(),
+		Level:   level,
+		Prefix:  logger.prefix,
+		Message: msg,
+		Fields:  logger.fields,
+	}
+	if len(keysAndValues) > 0 {
+		entry.Fields = make([]interface{}, 0, len(logger.fields)+len(keysAndValues))
+		entry.Fields = append(entry.Fields, logger.fields...)
+		entry.Fields = append(entry.Fields, keysAndValues...)
+	}
+	logger.write(&entry)
+}
+
+// write formats entry and writes it out, or passes it on to the slog
+// handler of a logger created by FromSlog.
+func (logger *Logger) write(entry *Entry) {
+	if logger.handler != nil {
+		logger.writeSlog(entry)
+		return
+	}
+	var buf bytes.Buffer
+
 	logger.mu.Lock()
 	defer logger.mu.Unlock()
-	// Handle color
-	if logger.useColor {
-		logger.out.Write(colors[level])
-		defer logger.out.Write(reset)
-	}
-	// Write the line out
-	fmt.Fprintf(logger.out, prefixFormat, timestamp, level.String(), logger.prefix)
-	fmt.Fprintf(logger.out, format, v...)
-	logger.out.Write([]byte{'\n'})
+	logger.formatter.Format(&buf, entry)
+	logger.out.Write(buf.Bytes())
 }
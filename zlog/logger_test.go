@@ -1,7 +1,13 @@
 package zlog
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"strings"
 	"testing"
+	"time"
 
 	. "gopkg.in/check.v1"
 )
@@ -25,3 +31,95 @@ func (s *LoggerSuite) TestPrint(c *C) {
 func (s *LoggerSuite) TestPrintf(c *C) {
 	Printf(LOG_ERROR, "THIS IS MAGENTA: %d == %d", 1, 1)
 }
+
+func (s *LoggerSuite) TestLevel(c *C) {
+	var out bytes.Buffer
+	logger := New(&out, "test")
+	c.Check(logger.Level(), Equals, LOG_TRACE)
+	logger.SetLevel(LOG_WARN)
+	c.Check(logger.Enabled(LOG_WARN), Equals, true)
+	c.Check(logger.Enabled(LOG_INFO), Equals, false)
+	logger.Infof("hidden %d", 1)
+	logger.Trace("hidden")
+	logger.Print(LOG_DEBUG, "hidden")
+	logger.Error("shown")
+	logger.Warnf("shown %d", 2)
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	c.Assert(lines, HasLen, 2)
+	c.Check(strings.HasSuffix(lines[0], " [ERROR] test: shown"), Equals, true)
+	c.Check(strings.HasSuffix(lines[1], " [WARN] test: shown 2"), Equals, true)
+}
+
+func (s *LoggerSuite) TestParseLevel(c *C) {
+	level, err := ParseLevel("debug")
+	c.Assert(err, IsNil)
+	c.Check(level, Equals, LOG_DEBUG)
+	_, err = ParseLevel("verbose")
+	c.Check(err, NotNil)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	level = LOG_INFO
+	fs.Var(&level, "log-level", "")
+	c.Assert(fs.Parse([]string{"-log-level", "ERROR"}), IsNil)
+	c.Check(level, Equals, LOG_ERROR)
+	c.Check(fs.Parse([]string{"-log-level", "loud"}), NotNil)
+}
+
+func (s *LoggerSuite) TestFields(c *C) {
+	var out bytes.Buffer
+	logger := New(&out, "scan")
+	host := logger.With("ip", "1.2.3.4", "port", 443)
+	host.Infow("handshake complete", "cipher_suite", "0xc02f", "error", "")
+	host.With("server", "nginx 1.2").Errorf("failed after %d tries", 3)
+	logger.Warnw("dangling", "key")
+	host.SetLevel(LOG_INFO)
+	host.Debugw("hidden")
+	logger.Debug("hidden")
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	c.Assert(lines, HasLen, 3)
+	c.Check(strings.HasSuffix(lines[0], ` [INFO] scan: handshake complete ip=1.2.3.4 port=443 cipher_suite=0xc02f error=""`), Equals, true, Commentf(lines[0]))
+	c.Check(strings.HasSuffix(lines[1], ` [ERROR] scan: failed after 3 tries ip=1.2.3.4 port=443 server="nginx 1.2"`), Equals, true, Commentf(lines[1]))
+	c.Check(strings.HasSuffix(lines[2], ` [WARN] scan: dangling key=(MISSING)`), Equals, true, Commentf(lines[2]))
+}
+
+func (s *LoggerSuite) TestTextFormatter(c *C) {
+	var buf bytes.Buffer
+	entry := &Entry{
+		Time:    time.Date(2026, 10, 18, 12, 0, 0, 123456789, time.UTC),
+		Level:   LOG_WARN,
+		Prefix:  "scan",
+		Message: "slow",
+		Fields:  []interface{}{"ip", "1.2.3.4", "note"},
+	}
+	(&TextFormatter{}).Format(&buf, entry)
+	c.Check(buf.String(), Equals, "Oct 18 12:00:00.123 [WARN] scan: slow ip=1.2.3.4 note=(MISSING)\n")
+	buf.Reset()
+	(&TextFormatter{Color: true}).Format(&buf, entry)
+	c.Check(buf.String(), Equals, colorYellow+"Oct 18 12:00:00.123 [WARN] scan: slow ip=1.2.3.4 note=(MISSING)\n"+colorReset)
+}
+
+func (s *LoggerSuite) TestJSONFormatter(c *C) {
+	var out bytes.Buffer
+	logger := New(&out, "scan")
+	logger.SetFormatter(&JSONFormatter{})
+	logger.Info("plain")
+	logger.With("ip", "1.2.3.4", "odd").Errorw("failed", "error", errors.New("EOF"), "port", 443, "ch", make(chan int))
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	c.Assert(lines, HasLen, 2)
+	var plain map[string]interface{}
+	c.Assert(json.Unmarshal([]byte(lines[0]), &plain), IsNil)
+	ts, err := time.Parse(time.RFC3339Nano, plain["timestamp"].(string))
+	c.Assert(err, IsNil)
+	c.Check(time.Since(ts) < time.Minute, Equals, true)
+	delete(plain, "timestamp")
+	c.Check(plain, DeepEquals, map[string]interface{}{"level": "INFO", "prefix": "scan", "message": "plain"})
+
+	c.Check(strings.Contains(lines[1], `,"level":"ERROR","prefix":"scan","message":"failed","fields":{"ip":"1.2.3.4","odd":"(MISSING)","error":"EOF","port":443,"ch":"0x`), Equals, true, Commentf(lines[1]))
+	var failed struct {
+		Fields map[string]interface{} `json:"fields"`
+	}
+	c.Assert(json.Unmarshal([]byte(lines[1]), &failed), IsNil)
+	c.Check(failed.Fields, HasLen, 5)
+}
@@ -0,0 +1,276 @@
+package zlog
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// backupTimeFormat is the layout of the timestamp in the names of rotated
+// files, chosen to sort in time order.
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// RotatingWriter is an io.Writer that appends to a log file and rotates it
+// once it grows too large or has been written to for too long, for use as
+// the output of a Logger:
+//
+//	w := &zlog.RotatingWriter{Filename: "scan.log", MaxSize: 1 << 30, MaxBackups: 5, Compress: true}
+//	defer w.Close()
+//	logger := zlog.New(w, "scan")
+//
+// A rotated file is renamed to the file name followed by the time of the
+// rotation, such as scan.log.2026-10-18T12-00-00.000, and then compressed
+// in the background if Compress is set. A Logger writes each message with a
+// single Write, so a message is never split across files. RotatingWriter
+// is safe for concurrent use, so it can be rotated or reopened while loggers
+// write to it.
+type RotatingWriter struct {
+	Filename string
+	// MaxSize is the size in bytes after which the file is rotated. Zero
+	// means no limit.
+	MaxSize int64
+	// RotateEvery is how long the file is written to before it is rotated.
+	// Zero means no limit.
+	RotateEvery time.Duration
+	// MaxAge is how long rotated files are kept, judged by the time in
+	// their names; older ones are removed whenever the file is rotated. Zero
+	// keeps them regardless of age.
+	MaxAge time.Duration
+	// MaxBackups is the number of rotated files to keep; older ones are
+	// removed. Zero keeps all of them.
+	MaxBackups int
+	// Compress gzips rotated files.
+	Compress bool
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+
+	// Compression and removal of backups happen in the background, one
+	// rotation at a time.
+	mill sync.Mutex
+	wg   sync.WaitGroup
+}
+
+func (w *RotatingWriter) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.Filename), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(w.Filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file = file
+	w.size = info.Size()
+	w.opened = time.Now()
+	return nil
+}
+
+func (w *RotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+	tooLarge := w.MaxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.MaxSize
+	tooOld := w.RotateEvery > 0 && time.Since(w.opened) >= w.RotateEvery
+	if tooLarge || tooOld {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Rotate rotates the file now, whatever its size and age.
+func (w *RotatingWriter) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rotate()
+}
+
+func (w *RotatingWriter) rotate() error {
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return err
+		}
+		w.file = nil
+	}
+	backup := w.backupName(time.Now())
+	if err := os.Rename(w.Filename, backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.mill.Lock()
+		defer w.mill.Unlock()
+		if w.Compress {
+			compressFile(backup)
+		}
+		w.removeOldBackups()
+	}()
+	return nil
+}
+
+// backupName returns an unused name for a file rotated at t.
+func (w *RotatingWriter) backupName(t time.Time) string {
+	for {
+		name := w.Filename + "." + t.Format(backupTimeFormat)
+		_, err := os.Lstat(name)
+		_, gzErr := os.Lstat(name + ".gz")
+		if os.IsNotExist(err) && os.IsNotExist(gzErr) {
+			return name
+		}
+		t = t.Add(time.Millisecond)
+	}
+}
+
+// Reopen closes the file and opens Filename again, for use after an
+// external tool such as logrotate has moved the file away.
+func (w *RotatingWriter) Reopen() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+	return w.open()
+}
+
+// ReopenOnSignal calls Reopen whenever the process receives one of the
+// given signals, SIGHUP if none are given, until stop is called. Failures to
+// reopen are retried on the next write.
+func (w *RotatingWriter) ReopenOnSignal(signals ...os.Signal) (stop func()) {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGHUP}
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, signals...)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-c:
+				w.Reopen()
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(c)
+			close(done)
+		})
+	}
+}
+
+// Close closes the file and waits for rotated files to be compressed. The
+// file is opened again on the next write.
+func (w *RotatingWriter) Close() error {
+	w.mu.Lock()
+	var err error
+	if w.file != nil {
+		err = w.file.Close()
+		w.file = nil
+	}
+	w.mu.Unlock()
+	w.wg.Wait()
+	return err
+}
+
+// compressFile replaces name by a gzipped copy. On failure, name is left in
+// place.
+func compressFile(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	_, err = io.Copy(gz, in)
+	if cerr := gz.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	return os.Remove(name)
+}
+
+// backupTime returns the time a rotated file was rotated at, from its name.
+func (w *RotatingWriter) backupTime(name string) (time.Time, error) {
+	stamp := strings.TrimSuffix(strings.TrimPrefix(name, w.Filename+"."), ".gz")
+	return time.ParseInLocation(backupTimeFormat, stamp, time.Local)
+}
+
+// backups returns the rotated files, oldest first.
+func (w *RotatingWriter) backups() ([]string, error) {
+	matches, err := filepath.Glob(w.Filename + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var backups []string
+	for _, name := range matches {
+		if _, err := w.backupTime(name); err == nil {
+			backups = append(backups, name)
+		}
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+func (w *RotatingWriter) removeOldBackups() {
+	if w.MaxBackups <= 0 && w.MaxAge <= 0 {
+		return
+	}
+	backups, err := w.backups()
+	if err != nil {
+		return
+	}
+	if w.MaxAge > 0 {
+		cutoff := time.Now().Add(-w.MaxAge)
+		for len(backups) > 0 {
+			t, _ := w.backupTime(backups[0])
+			if !t.Before(cutoff) {
+				break
+			}
+			os.Remove(backups[0])
+			backups = backups[1:]
+		}
+	}
+	for w.MaxBackups > 0 && len(backups) > w.MaxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+}
@@ -0,0 +1,129 @@
+package zlog
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type RotateSuite struct{}
+
+var _ = Suite(&RotateSuite{})
+
+func readFile(c *C, name string) string {
+	f, err := os.Open(name)
+	c.Assert(err, IsNil)
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(f)
+		c.Assert(err, IsNil)
+		r = gz
+	}
+	b, err := io.ReadAll(r)
+	c.Assert(err, IsNil)
+	return string(b)
+}
+
+func (s *RotateSuite) TestRotateBySize(c *C) {
+	dir := c.MkDir()
+	w := &RotatingWriter{Filename: filepath.Join(dir, "scan.log"), MaxSize: 100, MaxBackups: 2, Compress: true}
+	logger := New(w, "scan")
+	for i := 0; i < 10; i++ {
+		logger.Infof("message number %d", i)
+	}
+	c.Assert(w.Close(), IsNil)
+
+	backups, err := w.backups()
+	c.Assert(err, IsNil)
+	c.Assert(backups, HasLen, 2)
+	var all string
+	for _, name := range backups {
+		c.Check(strings.HasSuffix(name, ".gz"), Equals, true)
+		all += readFile(c, name)
+	}
+	current := readFile(c, w.Filename)
+	c.Check(len(current) <= 100, Equals, true)
+	all += current
+	// Every message that was kept is whole, and the newest ones are kept.
+	lines := strings.Split(strings.TrimSuffix(all, "\n"), "\n")
+	for _, line := range lines {
+		c.Check(strings.Contains(line, " [INFO] scan: message number "), Equals, true, Commentf(line))
+	}
+	c.Check(strings.HasSuffix(lines[len(lines)-1], "message number 9"), Equals, true)
+}
+
+func (s *RotateSuite) TestRotateEvery(c *C) {
+	dir := c.MkDir()
+	w := &RotatingWriter{Filename: filepath.Join(dir, "scan.log"), RotateEvery: 20 * time.Millisecond}
+	_, err := w.Write([]byte("first\n"))
+	c.Assert(err, IsNil)
+	time.Sleep(30 * time.Millisecond)
+	_, err = w.Write([]byte("second\n"))
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+	backups, err := w.backups()
+	c.Assert(err, IsNil)
+	c.Assert(backups, HasLen, 1)
+	c.Check(readFile(c, backups[0]), Equals, "first\n")
+	c.Check(readFile(c, w.Filename), Equals, "second\n")
+}
+
+func (s *RotateSuite) TestMaxAge(c *C) {
+	dir := c.MkDir()
+	w := &RotatingWriter{Filename: filepath.Join(dir, "scan.log"), MaxAge: time.Hour}
+	old := w.Filename + "." + time.Now().Add(-2*time.Hour).Format(backupTimeFormat)
+	recent := w.Filename + "." + time.Now().Add(-time.Minute).Format(backupTimeFormat)
+	for _, name := range []string{old, recent} {
+		c.Assert(os.WriteFile(name, []byte("old\n"), 0644), IsNil)
+	}
+	_, err := w.Write([]byte("first\n"))
+	c.Assert(err, IsNil)
+	c.Assert(w.Rotate(), IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	backups, err := w.backups()
+	c.Assert(err, IsNil)
+	c.Assert(backups, HasLen, 2)
+	c.Check(backups[0], Equals, recent)
+	c.Check(readFile(c, backups[1]), Equals, "first\n")
+}
+
+func (s *RotateSuite) TestReopenOnSignal(c *C) {
+	if runtime.GOOS == "windows" {
+		c.Skip("signals cannot be sent on windows")
+	}
+	dir := c.MkDir()
+	name := filepath.Join(dir, "scan.log")
+	w := &RotatingWriter{Filename: name}
+	stop := w.ReopenOnSignal()
+	defer stop()
+	_, err := w.Write([]byte("before\n"))
+	c.Assert(err, IsNil)
+
+	// Rotate the way logrotate does, then ask for the file to be reopened.
+	c.Assert(os.Rename(name, name+".1"), IsNil)
+	self, err := os.FindProcess(os.Getpid())
+	c.Assert(err, IsNil)
+	c.Assert(self.Signal(syscall.SIGHUP), IsNil)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(name); err == nil {
+			break
+		}
+		c.Assert(time.Now().Before(deadline), Equals, true)
+		time.Sleep(5 * time.Millisecond)
+	}
+	_, err = w.Write([]byte("after\n"))
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+	c.Check(readFile(c, name+".1"), Equals, "before\n")
+	c.Check(readFile(c, name), Equals, "after\n")
+}
@@ -0,0 +1,165 @@
+package zlog
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Levels of slog records that correspond to LOG_TRACE and LOG_FATAL, which
+// slog has no levels for.
+const (
+	SlogLevelTrace = slog.LevelDebug - 4
+	SlogLevelFatal = slog.LevelError + 4
+)
+
+// levelFromSlog returns the zlog level of a slog level, rounding down to
+// the nearest less severe level.
+func levelFromSlog(level slog.Level) LogLevel {
+	switch {
+	case level >= SlogLevelFatal:
+		return LOG_FATAL
+	case level >= slog.LevelError:
+		return LOG_ERROR
+	case level >= slog.LevelWarn:
+		return LOG_WARN
+	case level >= slog.LevelInfo:
+		return LOG_INFO
+	case level >= slog.LevelDebug:
+		return LOG_DEBUG
+	}
+	return LOG_TRACE
+}
+
+// SlogLevel returns the slog level corresponding to level.
+func (level LogLevel) SlogLevel() slog.Level {
+	switch level {
+	case LOG_FATAL:
+		return SlogLevelFatal
+	case LOG_ERROR:
+		return slog.LevelError
+	case LOG_WARN:
+		return slog.LevelWarn
+	case LOG_INFO:
+		return slog.LevelInfo
+	case LOG_DEBUG:
+		return slog.LevelDebug
+	}
+	return SlogLevelTrace
+}
+
+// SlogHandler is a slog.Handler that writes records through a Logger, so
+// that code using log/slog shares the output, level and format of zlog:
+//
+//	slog.SetDefault(slog.New(zlog.NewSlogHandler(logger)))
+//
+// Attributes become fields of the message, with the names of the groups
+// they are in joined by dots, such as "tls.version". Records at
+// SlogLevelFatal or above are logged at LOG_FATAL, but do not exit.
+type SlogHandler struct {
+	logger *Logger
+	fields []interface{}
+	group  string
+}
+
+func NewSlogHandler(logger *Logger) *SlogHandler {
+	return &SlogHandler{logger: logger}
+}
+
+func (h *SlogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.logger.Enabled(levelFromSlog(level))
+}
+
+func (h *SlogHandler) Handle(_ context.Context, r slog.Record) error {
+	level := levelFromSlog(r.Level)
+	if !h.logger.Enabled(level) {
+		return nil
+	}
+	entry := Entry{
+		Time:    r.Time,
+		Level:   level,
+		Prefix:  h.logger.prefix,
+		Message: r.Message,
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	entry.Fields = make([]interface{}, 0, len(h.logger.fields)+len(h.fields)+2*r.NumAttrs())
+	entry.Fields = append(entry.Fields, h.logger.fields...)
+	entry.Fields = append(entry.Fields, h.fields...)
+	r.Attrs(func(a slog.Attr) bool {
+		entry.Fields = appendAttr(entry.Fields, h.group, a)
+		return true
+	})
+	h.logger.write(&entry)
+	return nil
+}
+
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	child := *h
+	child.fields = make([]interface{}, 0, len(h.fields)+2*len(attrs))
+	child.fields = append(child.fields, h.fields...)
+	for _, a := range attrs {
+		child.fields = appendAttr(child.fields, h.group, a)
+	}
+	return &child
+}
+
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	child := *h
+	child.group = h.group + name + "."
+	return &child
+}
+
+// appendAttr appends a as a key and a value, or the attributes of a group
+// as keys under its name, to fields.
+func appendAttr(fields []interface{}, group string, a slog.Attr) []interface{} {
+	value := a.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		attrs := value.Group()
+		if len(attrs) == 0 {
+			return fields
+		}
+		if a.Key != "" {
+			group += a.Key + "."
+		}
+		for _, ga := range attrs {
+			fields = appendAttr(fields, group, ga)
+		}
+		return fields
+	}
+	if a.Equal(slog.Attr{}) {
+		return fields
+	}
+	return append(fields, group+a.Key, value.Any())
+}
+
+// FromSlog returns a Logger that passes its messages on to the handler of
+// l, so that code logging through zlog can be routed into an existing
+// slog.Logger. Fields become attributes, and the prefix, if not empty, a
+// "prefix" attribute. The level of the returned logger still applies, as
+// does Fatal exiting.
+func FromSlog(l *slog.Logger, prefix string) *Logger {
+	logger := New(nil, prefix)
+	logger.handler = l.Handler()
+	return logger
+}
+
+func (logger *Logger) writeSlog(entry *Entry) {
+	ctx := context.Background()
+	level := entry.Level.SlogLevel()
+	if !logger.handler.Enabled(ctx, level) {
+		return
+	}
+	r := slog.NewRecord(entry.Time, level, entry.Message, 0)
+	if entry.Prefix != "" {
+		r.AddAttrs(slog.String("prefix", entry.Prefix))
+	}
+	pairs(entry.Fields, func(key string, value interface{}) {
+		r.AddAttrs(slog.Any(key, value))
+	})
+	logger.handler.Handle(ctx, r)
+}
@@ -0,0 +1,58 @@
+package zlog
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type SlogSuite struct{}
+
+var _ = Suite(&SlogSuite{})
+
+func (s *SlogSuite) TestHandler(c *C) {
+	var out bytes.Buffer
+	logger := New(&out, "scan").With("run", 7)
+	logger.SetLevel(LOG_INFO)
+	l := slog.New(NewSlogHandler(logger))
+
+	c.Check(l.Enabled(context.Background(), slog.LevelDebug), Equals, false)
+	l.Debug("hidden")
+	l.With("ip", "1.2.3.4").WithGroup("tls").Info("handshake", "version", 771,
+		slog.Group("cert", "issuer", "CA One", slog.Group("empty")), slog.Group("", "inline", true))
+	l.Log(context.Background(), SlogLevelFatal, "fatal, but still running")
+	l.Warn("slow", slog.Any("err", nil), slog.Attr{})
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	c.Assert(lines, HasLen, 3)
+	c.Check(strings.HasSuffix(lines[0], " [INFO] scan: handshake run=7 ip=1.2.3.4 tls.version=771 tls.cert.issuer=\"CA One\" tls.inline=true"), Equals, true, Commentf(lines[0]))
+	c.Check(strings.HasSuffix(lines[1], " [FATAL] scan: fatal, but still running run=7"), Equals, true, Commentf(lines[1]))
+	c.Check(strings.HasSuffix(lines[2], " [WARN] scan: slow run=7 err=<nil>"), Equals, true, Commentf(lines[2]))
+}
+
+func (s *SlogSuite) TestFromSlog(c *C) {
+	var out bytes.Buffer
+	l := slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	logger := FromSlog(l, "scan")
+	logger.With("ip", "1.2.3.4").Infow("handshake", "cipher_suite", "0xc02f")
+	logger.Errorf("failed after %d tries", 3)
+	logger.Trace("below the handler's level")
+	logger.SetLevel(LOG_WARN)
+	logger.Debug("below the logger's level")
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	c.Assert(lines, HasLen, 2)
+	c.Check(strings.HasSuffix(lines[0], " level=INFO msg=handshake prefix=scan ip=1.2.3.4 cipher_suite=0xc02f"), Equals, true, Commentf(lines[0]))
+	c.Check(strings.HasSuffix(lines[1], ` level=ERROR msg="failed after 3 tries" prefix=scan`), Equals, true, Commentf(lines[1]))
+}
+
+func (s *SlogSuite) TestLevels(c *C) {
+	for _, level := range []LogLevel{LOG_FATAL, LOG_ERROR, LOG_WARN, LOG_INFO, LOG_DEBUG, LOG_TRACE} {
+		c.Check(levelFromSlog(level.SlogLevel()), Equals, level)
+	}
+	c.Check(levelFromSlog(slog.LevelInfo+2), Equals, LOG_INFO)
+	c.Check(levelFromSlog(slog.LevelDebug-1), Equals, LOG_TRACE)
+}